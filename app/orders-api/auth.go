@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/praivan/orders-core/principal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ---- Authentication ----
+//
+// Every route except the probes and /metrics needs a principal: GET and HEAD
+// require the orders:read scope, everything else orders:write. Callers
+// authenticate with a static API key (X-API-Key, "Authorization: Bearer <key>"
+// or as the Basic auth password, which lets browsers use the HTML page) or
+// with a JWT bearer token signed by a key from the configured JWKS. The
+// principal is stored with new orders and status transitions and labels
+// orders_http_requests_by_principal_total. Failed attempts are limited per
+// client IP (see limits.go).
+
+const (
+	scopeRead  = "orders:read"
+	scopeWrite = "orders:write"
+)
+
+var knownScopes = []string{scopeRead, scopeWrite}
+
+var (
+	// errNoCredentials means the request carries no credentials the
+	// authenticator understands; the next one in the chain is tried.
+	errNoCredentials = errors.New("no credentials")
+	// errInvalidCredentials hides why credentials were rejected from clients.
+	errInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// ID identifies the caller in logs and audit columns, e.g.
+	// "apikey:checkout" or "jwt:user-42".
+	ID string
+	// Client labels metrics. Unlike ID it is bounded: the API key name or
+	// the JWT's client, never its subject.
+	Client string
+	Scopes []string
+}
+
+// HasScope reports whether p was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// Authenticator turns a request's credentials into a Principal.
+type Authenticator interface {
+	// Authenticate returns errNoCredentials if r has no credentials of its
+	// kind, and errInvalidCredentials (possibly wrapped) if they are wrong.
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// authChain tries each authenticator until one recognises the credentials.
+type authChain []Authenticator
+
+func (c authChain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, errNoCredentials
+}
+
+// anonymousID is the principal of every request when auth is disabled.
+const anonymousID = "anonymous"
+
+// anonymous serves every request with all scopes when auth is disabled.
+type anonymous struct{}
+
+func (anonymous) Authenticate(*http.Request) (*Principal, error) {
+	return &Principal{ID: anonymousID, Client: anonymousID, Scopes: knownScopes}, nil
+}
+
+// newAuthenticator builds the chain configured by cfg.
+func newAuthenticator(cfg AuthConfig) (Authenticator, *keySet, error) {
+	if cfg.Disabled {
+		return anonymous{}, nil, nil
+	}
+
+	var (
+		chain authChain
+		keys  *keySet
+	)
+	if cfg.JWT.Enabled() {
+		var err error
+		keys, err = newKeySet(cfg.JWT)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, newJWTAuth(cfg.JWT, keys))
+	}
+	if cfg.APIKeys != "" {
+		apiKeys, err := parseAPIKeys(string(cfg.APIKeys))
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, apiKeys)
+	}
+	return chain, keys, nil
+}
+
+// requiredScope maps a request to the scope it needs.
+func requiredScope(r *http.Request) string {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return scopeRead
+	}
+	return scopeWrite
+}
+
+type principalKey struct{}
+
+// principalFrom returns the principal set by withAuth, or nil.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// withAuth rejects requests without a principal holding the required scope.
+// failures, when not nil, counts failed attempts per client IP and answers
+// 429 once an IP has used up its bucket.
+func withAuth(authn Authenticator, failures *RateLimiter, proxies []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ipKey := ""
+		if failures != nil {
+			ipKey = "ip:" + clientIP(r, proxies)
+			if wait, empty := failures.empty(ipKey); empty {
+				requestsThrottledTotal.WithLabelValues("auth_failure").Inc()
+				logger.DebugContext(r.Context(), "auth_throttled",
+					"key", ipKey,
+					"method", r.Method,
+					"path", r.URL.Path,
+				)
+				w.Header().Set("Retry-After", retryAfter(wait))
+				writeJSONError(w, http.StatusTooManyRequests, "too many failed authentication attempts")
+				return
+			}
+		}
+
+		p, err := authn.Authenticate(r)
+		if err != nil {
+			reason := "invalid"
+			if errors.Is(err, errNoCredentials) {
+				reason = "missing"
+			}
+			if failures != nil {
+				failures.allow(ipKey)
+			}
+			authFailuresTotal.WithLabelValues(reason).Inc()
+			logger.WarnContext(r.Context(), "auth_failed",
+				"reason", reason,
+				"method", r.Method,
+				"path", r.URL.Path,
+				"error", err.Error(),
+			)
+			w.Header().Add("WWW-Authenticate", `Bearer realm="orders-api"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="orders-api", charset="UTF-8"`)
+			writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		ctx := principal.WithContext(r.Context(), p.ID)
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("enduser.id", p.ID))
+
+		if scope := requiredScope(r); !p.HasScope(scope) {
+			authFailuresTotal.WithLabelValues("forbidden").Inc()
+			logger.WarnContext(ctx, "auth_forbidden",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"scope", scope,
+			)
+			w.Header().Set("WWW-Authenticate",
+				fmt.Sprintf(`Bearer realm="orders-api", error="insufficient_scope", scope=%q`, scope))
+			writeJSONError(w, http.StatusForbidden, "missing scope "+scope)
+			return
+		}
+
+		ctx = context.WithValue(ctx, principalKey{}, p)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// looksLikeJWT tells compact JWS tokens from opaque API keys.
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// ---- API keys ----
+
+// apiKeys maps the SHA-256 of each key to its principal. Keys are random
+// secrets, so a fast unsalted hash is enough to keep them out of config.
+type apiKeys map[[sha256.Size]byte]*Principal
+
+// parseAPIKeys parses AuthConfig.APIKeys entries.
+func parseAPIKeys(s string) (apiKeys, error) {
+	keys := apiKeys{}
+	names := map[string]bool{}
+	entries := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ';' })
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		fields := strings.Fields(entry)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("entry %d: want \"<name> <sha256 hex> <scopes>\"", i+1)
+		}
+		name, hash, scopeList := fields[0], fields[1], fields[2]
+
+		b, err := hex.DecodeString(hash)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("entry %d (%s): key hash must be 64 hex characters", i+1, name)
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], b)
+		if names[name] {
+			return nil, fmt.Errorf("entry %d: duplicate name %q", i+1, name)
+		}
+		if _, dup := keys[sum]; dup {
+			return nil, fmt.Errorf("entry %d (%s): duplicate key hash", i+1, name)
+		}
+
+		scopes := strings.Split(scopeList, ",")
+		for _, scope := range scopes {
+			if !slices.Contains(knownScopes, scope) {
+				return nil, fmt.Errorf("entry %d (%s): unknown scope %q", i+1, name, scope)
+			}
+		}
+
+		names[name] = true
+		keys[sum] = &Principal{ID: "apikey:" + name, Client: "apikey:" + name, Scopes: scopes}
+	}
+	return keys, nil
+}
+
+func (k apiKeys) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		if token := bearerToken(r); token != "" && !looksLikeJWT(token) {
+			key = token
+		}
+	}
+	if key == "" {
+		if _, password, ok := r.BasicAuth(); ok {
+			key = password
+		}
+	}
+	if key == "" {
+		return nil, errNoCredentials
+	}
+
+	p, ok := k[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown API key", errInvalidCredentials)
+	}
+	return p, nil
+}
+
+// ---- JWT bearer tokens ----
+
+// jwtLeeway tolerates clock skew between the issuer and this service.
+const jwtLeeway = 30 * time.Second
+
+type jwtAuth struct {
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+// tokenClaims are the claims read from access tokens. Scopes come from the
+// space separated "scope" claim (RFC 8693) or the "scp" list some issuers use.
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope    string          `json:"scope,omitempty"`
+	Scp      json.RawMessage `json:"scp,omitempty"`
+	AZP      string          `json:"azp,omitempty"`
+	ClientID string          `json:"client_id,omitempty"`
+}
+
+func (c *tokenClaims) scopes() []string {
+	scopes := strings.Fields(c.Scope)
+	var list []string
+	var single string
+	switch {
+	case json.Unmarshal(c.Scp, &list) == nil:
+		scopes = append(scopes, list...)
+	case json.Unmarshal(c.Scp, &single) == nil:
+		scopes = append(scopes, strings.Fields(single)...)
+	}
+	return scopes
+}
+
+func newJWTAuth(cfg JWTConfig, keys *keySet) *jwtAuth {
+	return &jwtAuth{
+		keys: keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(jwtAlgorithms),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(jwtLeeway),
+		),
+	}
+}
+
+func (a *jwtAuth) Authenticate(r *http.Request) (*Principal, error) {
+	token := bearerToken(r)
+	if token == "" || !looksLikeJWT(token) {
+		return nil, errNoCredentials
+	}
+
+	var claims tokenClaims
+	_, err := a.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.lookup(r.Context(), kid, t.Method.Alg())
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidCredentials, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", errInvalidCredentials)
+	}
+
+	client := claims.AZP
+	if client == "" {
+		client = claims.ClientID
+	}
+	label := "jwt"
+	if client != "" {
+		label = "jwt:" + client
+	}
+	return &Principal{ID: "jwt:" + claims.Subject, Client: label, Scopes: claims.scopes()}, nil
+}
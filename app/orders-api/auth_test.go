@@ -0,0 +1,389 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseAPIKeys(t *testing.T) {
+	web, batch := keyHash("web-secret"), keyHash("batch-secret")
+
+	tests := []struct {
+		name    string
+		in      string
+		want    map[string][]string // principal ID -> scopes
+		wantErr string
+	}{
+		{name: "empty", in: "", want: map[string][]string{}},
+		{
+			name: "newline and semicolon separated",
+			in:   "# comment\nweb " + web + " orders:read\n\n;batch " + batch + " orders:read,orders:write",
+			want: map[string][]string{
+				"apikey:web":   {scopeRead},
+				"apikey:batch": {scopeRead, scopeWrite},
+			},
+		},
+		{name: "missing scopes", in: "web " + web, wantErr: "entry 1: want"},
+		{name: "short hash", in: "web abcd orders:read", wantErr: "entry 1 (web): key hash must be 64 hex characters"},
+		{name: "hash not hex", in: "web " + strings.Repeat("z", 64) + " orders:read", wantErr: "64 hex characters"},
+		{name: "unknown scope", in: "web " + web + " orders:admin", wantErr: `unknown scope "orders:admin"`},
+		{
+			name:    "duplicate name",
+			in:      "web " + web + " orders:read\nweb " + batch + " orders:read",
+			wantErr: `entry 2: duplicate name "web"`,
+		},
+		{
+			name:    "duplicate hash",
+			in:      "web " + web + " orders:read\nbatch " + web + " orders:read",
+			wantErr: "entry 2 (batch): duplicate key hash",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseAPIKeys(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseAPIKeys error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAPIKeys: %v", err)
+			}
+			got := map[string][]string{}
+			for _, p := range keys {
+				got[p.ID] = p.Scopes
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAPIKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeysAuthenticate(t *testing.T) {
+	keys, err := parseAPIKeys("web " + keyHash("web-secret") + " orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		basic   string
+		wantID  string
+		wantErr error
+	}{
+		{name: "X-API-Key", header: http.Header{"X-Api-Key": {"web-secret"}}, wantID: "apikey:web"},
+		{name: "bearer", header: http.Header{"Authorization": {"Bearer web-secret"}}, wantID: "apikey:web"},
+		{name: "basic password", basic: "web-secret", wantID: "apikey:web"},
+		{name: "wrong key", header: http.Header{"X-Api-Key": {"guess"}}, wantErr: errInvalidCredentials},
+		{name: "no credentials", wantErr: errNoCredentials},
+		{name: "JWT is left to the JWT authenticator", header: http.Header{"Authorization": {"Bearer a.b.c"}}, wantErr: errNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			for k, vs := range tt.header {
+				r.Header[k] = vs
+			}
+			if tt.basic != "" {
+				r.SetBasicAuth("anything", tt.basic)
+			}
+			p, err := keys.Authenticate(r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Authenticate error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if p.ID != tt.wantID {
+				t.Errorf("principal = %q, want %q", p.ID, tt.wantID)
+			}
+		})
+	}
+}
+
+// testJWT is a JWT authenticator backed by a fresh ES256 key.
+type testJWT struct {
+	auth *jwtAuth
+	priv *ecdsa.PrivateKey
+	kid  string
+}
+
+func newTestJWT(t *testing.T) *testJWT {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := publicJWK(&priv.PublicKey)
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{jwk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := JWTConfig{JWKS: string(jwks), Issuer: "orders-test", Audience: "orders-api"}
+	keys, err := newKeySet(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testJWT{auth: newJWTAuth(cfg, keys), priv: priv, kid: jwk["kid"]}
+}
+
+// claims returns valid claims for a token with scope.
+func (j *testJWT) claims(scope string) tokenClaims {
+	now := time.Now()
+	return tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "orders-test",
+			Subject:   "user-42",
+			Audience:  jwt.ClaimStrings{"orders-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Scope: scope,
+		AZP:   "checkout",
+	}
+}
+
+func (j *testJWT) sign(t *testing.T, c tokenClaims, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, c)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(j.priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	j := newTestJWT(t)
+	other := newTestJWT(t)
+
+	tests := []struct {
+		name    string
+		token   func() string
+		wantErr bool
+	}{
+		{name: "valid", token: func() string { return j.sign(t, j.claims(scopeRead), j.kid) }},
+		{
+			name: "expired within leeway",
+			token: func() string {
+				c := j.claims(scopeRead)
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-jwtLeeway / 2))
+				return j.sign(t, c, j.kid)
+			},
+		},
+		{
+			name: "expired",
+			token: func() string {
+				c := j.claims(scopeRead)
+				c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+				return j.sign(t, c, j.kid)
+			},
+			wantErr: true,
+		},
+		{
+			name: "no expiry",
+			token: func() string {
+				c := j.claims(scopeRead)
+				c.ExpiresAt = nil
+				return j.sign(t, c, j.kid)
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				c := j.claims(scopeRead)
+				c.Audience = jwt.ClaimStrings{"billing-api"}
+				return j.sign(t, c, j.kid)
+			},
+			wantErr: true,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				c := j.claims(scopeRead)
+				c.Issuer = "someone-else"
+				return j.sign(t, c, j.kid)
+			},
+			wantErr: true,
+		},
+		{
+			name: "no subject",
+			token: func() string {
+				c := j.claims(scopeRead)
+				c.Subject = ""
+				return j.sign(t, c, j.kid)
+			},
+			wantErr: true,
+		},
+		{name: "unknown kid", token: func() string { return j.sign(t, j.claims(scopeRead), "nope") }, wantErr: true},
+		{name: "signed by another key", token: func() string { return other.sign(t, j.claims(scopeRead), j.kid) }, wantErr: true},
+		{
+			name: "HS256 with the public key",
+			token: func() string {
+				token := jwt.NewWithClaims(jwt.SigningMethodHS256, j.claims(scopeRead))
+				token.Header["kid"] = j.kid
+				signed, err := token.SignedString([]byte(j.kid))
+				if err != nil {
+					t.Fatal(err)
+				}
+				return signed
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token())
+			p, err := j.auth.Authenticate(r)
+			if tt.wantErr {
+				if !errors.Is(err, errInvalidCredentials) {
+					t.Fatalf("Authenticate error = %v, want %v", err, errInvalidCredentials)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			want := &Principal{ID: "jwt:user-42", Client: "jwt:checkout", Scopes: []string{scopeRead}}
+			if !reflect.DeepEqual(p, want) {
+				t.Errorf("principal = %+v, want %+v", p, want)
+			}
+		})
+	}
+}
+
+func TestTokenClaimsScopes(t *testing.T) {
+	tests := []struct {
+		name  string
+		scope string
+		scp   string
+		want  []string
+	}{
+		{name: "scope", scope: "orders:read orders:write", want: []string{scopeRead, scopeWrite}},
+		{name: "scp list", scp: `["orders:read","orders:write"]`, want: []string{scopeRead, scopeWrite}},
+		{name: "scp string", scp: `"orders:write"`, want: []string{scopeWrite}},
+		{name: "both", scope: "orders:read", scp: `["orders:write"]`, want: []string{scopeRead, scopeWrite}},
+		{name: "none", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := tokenClaims{Scope: tt.scope}
+			if tt.scp != "" {
+				c.Scp = json.RawMessage(tt.scp)
+			}
+			got := c.scopes()
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scopes() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	j := newTestJWT(t)
+	keys, err := parseAPIKeys("web " + keyHash("web-secret") + " orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authn := authChain{j.auth, keys}
+
+	var got *Principal
+	h := withAuth(authn, nil, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = principalFrom(r.Context())
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   string
+		want   int
+		wantID string
+	}{
+		{name: "probe needs no credentials", method: http.MethodGet, path: "/healthz", want: http.StatusOK},
+		{name: "missing credentials", method: http.MethodGet, path: "/orders", want: http.StatusUnauthorized},
+		{
+			name:   "read with read scope",
+			method: http.MethodGet,
+			path:   "/orders",
+			auth:   "Bearer " + j.sign(t, j.claims(scopeRead), j.kid),
+			want:   http.StatusOK,
+			wantID: "jwt:user-42",
+		},
+		{
+			name:   "write without write scope",
+			method: http.MethodPost,
+			path:   "/orders",
+			auth:   "Bearer " + j.sign(t, j.claims(scopeRead), j.kid),
+			want:   http.StatusForbidden,
+		},
+		{
+			name:   "write with write scope",
+			method: http.MethodPost,
+			path:   "/orders",
+			auth:   "Bearer " + j.sign(t, j.claims(scopeRead+" "+scopeWrite), j.kid),
+			want:   http.StatusOK,
+			wantID: "jwt:user-42",
+		},
+		{name: "API key", method: http.MethodGet, path: "/orders", auth: "Bearer web-secret", want: http.StatusOK, wantID: "apikey:web"},
+		{name: "API key without write scope", method: http.MethodDelete, path: "/orders/o-1", auth: "Bearer web-secret", want: http.StatusForbidden},
+		{name: "bad API key", method: http.MethodGet, path: "/orders", auth: "Bearer guess", want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.want, w.Body)
+			}
+			switch w.Code {
+			case http.StatusUnauthorized:
+				if len(w.Header().Values("WWW-Authenticate")) != 2 {
+					t.Errorf("WWW-Authenticate = %q, want Bearer and Basic", w.Header().Values("WWW-Authenticate"))
+				}
+			case http.StatusForbidden:
+				if !strings.Contains(w.Header().Get("WWW-Authenticate"), `error="insufficient_scope"`) {
+					t.Errorf("WWW-Authenticate = %q, want insufficient_scope", w.Header().Get("WWW-Authenticate"))
+				}
+			}
+			if tt.wantID != "" && (got == nil || got.ID != tt.wantID) {
+				t.Errorf("principal = %+v, want %q", got, tt.wantID)
+			}
+		})
+	}
+}
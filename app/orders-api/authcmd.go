@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ---- `orders-api auth` ----
+//
+// Helpers for provisioning credentials and a local stand-in for an identity
+// provider: dev-jwks writes an ES256 key pair whose jwks.json can be passed
+// as AUTH_JWKS_FILE, dev-token signs access tokens with it.
+
+const authUsage = `usage:
+  orders-api auth new-key <name> [scopes]   print a random API key and its auth.api_keys entry
+  orders-api auth dev-jwks <dir>            write <dir>/jwks.json and <dir>/signing-key.pem
+  orders-api auth dev-token -key <pem> [-sub s] [-scope s] [-iss s] [-aud s] [-client s] [-ttl d]`
+
+func runAuthCommand(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(authUsage)
+	}
+	switch args[0] {
+	case "new-key":
+		return newAPIKey(args[1:], out)
+	case "dev-jwks":
+		return writeDevJWKS(args[1:], out)
+	case "dev-token":
+		return signDevToken(args[1:], out)
+	}
+	return errors.New(authUsage)
+}
+
+func newAPIKey(args []string, out io.Writer) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New(authUsage)
+	}
+	scopes := strings.Join(knownScopes, ",")
+	if len(args) == 2 {
+		scopes = args[1]
+	}
+	entry := args[0] + " " + strings.Repeat("0", 2*sha256.Size) + " " + scopes
+	if _, err := parseAPIKeys(entry); err != nil {
+		return err
+	}
+
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return err
+	}
+	key := base64.RawURLEncoding.EncodeToString(b[:])
+	sum := sha256.Sum256([]byte(key))
+	fmt.Fprintf(out, "key:   %s\nentry: %s %s %s\n", key, args[0], hex.EncodeToString(sum[:]), scopes)
+	return nil
+}
+
+func writeDevJWKS(args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New(authUsage)
+	}
+	dir := args[0]
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return err
+	}
+	jwks, err := json.MarshalIndent(map[string]any{
+		"keys": []map[string]string{publicJWK(&priv.PublicKey)},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	keyPath := filepath.Join(dir, "signing-key.pem")
+	jwksPath := filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(jwksPath, append(jwks, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "wrote %s and %s\n", jwksPath, keyPath)
+	return nil
+}
+
+// publicJWK returns the JWK of key with its RFC 7638 thumbprint as kid, so
+// dev-token can derive the kid from the private key alone.
+func publicJWK(key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	x := base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, size)))
+	y := base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, size)))
+
+	// Members in lexicographic order, no whitespace.
+	thumbprint := sha256.Sum256([]byte(`{"crv":"P-256","kty":"EC","x":"` + x + `","y":"` + y + `"}`))
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"use": "sig",
+		"alg": "ES256",
+		"kid": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		"x":   x,
+		"y":   y,
+	}
+}
+
+func signDevToken(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("dev-token", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var (
+		keyPath = fs.String("key", "", "signing-key.pem written by dev-jwks")
+		sub     = fs.String("sub", "dev", "subject")
+		scope   = fs.String("scope", strings.Join(knownScopes, " "), "space separated scopes")
+		iss     = fs.String("iss", "orders-dev", "issuer (auth.jwt.issuer)")
+		aud     = fs.String("aud", "orders-api", "audience (auth.jwt.audience)")
+		client  = fs.String("client", "", "client ID (azp), used as the metric label")
+		ttl     = fs.Duration("ttl", time.Hour, "lifetime")
+	)
+	if err := fs.Parse(args); err != nil || *keyPath == "" {
+		return errors.New(authUsage)
+	}
+
+	b, err := os.ReadFile(*keyPath)
+	if err != nil {
+		return err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return fmt.Errorf("%s: no PEM data", *keyPath)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *keyPath, err)
+	}
+	priv, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || priv.Curve != elliptic.P256() {
+		return fmt.Errorf("%s: not a P-256 key", *keyPath)
+	}
+
+	now := time.Now()
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    *iss,
+			Subject:   *sub,
+			Audience:  jwt.ClaimStrings{*aud},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(*ttl)),
+		},
+		Scope: *scope,
+		AZP:   *client,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = publicJWK(&priv.PublicKey)["kid"]
+	signed, err := token.SignedString(priv)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, signed)
+	return nil
+}
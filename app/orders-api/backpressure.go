@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ---- Backpressure ----
+//
+// The Publisher inspects the orders queue every backpressure.interval with a
+// passive declare on a short-lived channel and tracks RabbitMQ's
+// connection.blocked notifications. New orders from POST /orders and POST
+// /orders:batch are rejected with 503 and Retry-After while the broker blocks
+// publishers, the queue has no consumer or more than backpressure.max_depth
+// ready messages, so clients back off instead of building a backlog that
+// takes hours to drain. Repeats of accepted requests still get their stored
+// response, and uploads stop at the first new order. An inspection older
+// than backpressureStaleAfter intervals (e.g. while disconnected) is ignored:
+// the outbox absorbs broker outages on its own.
+
+const backpressureStaleAfter = 3
+
+// ErrBackpressure is returned by Admit while new orders are rejected.
+var ErrBackpressure = errors.New("orders queue saturated")
+
+// queueState is the result of the last successful inspection.
+type queueState struct {
+	depth     int
+	consumers int
+	at        time.Time
+}
+
+// Admit returns nil if new orders can be accepted, or an error wrapping
+// ErrBackpressure. Its reason is one of blocked, no_consumers, queue_depth.
+func (p *Publisher) Admit() error {
+	p.mu.RLock()
+	blocked, reason, q := p.blocked, p.blockedReason, p.queue
+	p.mu.RUnlock()
+
+	cfg := p.cfg.Backpressure
+	switch {
+	case blocked:
+		return &backpressureError{reason: "blocked", detail: reason}
+	case time.Since(q.at) > backpressureStaleAfter*cfg.Interval:
+		return nil
+	case cfg.RequireConsumers && q.consumers == 0:
+		return &backpressureError{reason: "no_consumers"}
+	case cfg.MaxDepth > 0 && q.depth > cfg.MaxDepth:
+		return &backpressureError{reason: "queue_depth", detail: fmt.Sprintf("%d > %d", q.depth, cfg.MaxDepth)}
+	}
+	return nil
+}
+
+// rejectSaturated answers 503 with Retry-After and returns true if err came
+// from Admit.
+func rejectSaturated(w http.ResponseWriter, r *http.Request, err error, retry time.Duration) bool {
+	if !countRejection(err) {
+		return false
+	}
+	w.Header().Set("Retry-After", retryAfter(retry))
+	writeJSONError(w, http.StatusServiceUnavailable, "orders queue saturated, retry later")
+	logger.DebugContext(r.Context(), "order_rejected_backpressure", "reason", err.Error())
+	return true
+}
+
+// countRejection counts err in orders_backpressure_rejections_total and
+// reports whether it came from Admit.
+func countRejection(err error) bool {
+	var saturated *backpressureError
+	if !errors.As(err, &saturated) {
+		return false
+	}
+	backpressureRejectionsTotal.WithLabelValues(saturated.reason).Inc()
+	return true
+}
+
+type backpressureError struct {
+	reason string
+	detail string
+}
+
+func (e *backpressureError) Error() string {
+	if e.detail == "" {
+		return fmt.Sprintf("%s: %s", ErrBackpressure, e.reason)
+	}
+	return fmt.Sprintf("%s: %s (%s)", ErrBackpressure, e.reason, e.detail)
+}
+
+func (e *backpressureError) Unwrap() error { return ErrBackpressure }
+
+// inspectLoop inspects the queue every interval until Close is called.
+func (p *Publisher) inspectLoop() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.cfg.Backpressure.Interval)
+	defer ticker.Stop()
+	for {
+		p.inspect()
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// inspect records the queue's ready message and consumer counts. It uses its
+// own channel because a failed passive declare closes the channel, and skips
+// blocked connections, where opening a channel would hang.
+func (p *Publisher) inspect() {
+	p.mu.RLock()
+	conn, blocked := p.conn, p.blocked
+	p.mu.RUnlock()
+	if conn == nil || conn.IsClosed() || blocked {
+		return
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		logger.Warn("rabbitmq_inspect_failed", "queue", p.queueName, "error", err.Error())
+		return
+	}
+	defer func() { _ = ch.Close() }()
+
+	q, err := ch.QueueDeclarePassive(p.queueName, true, false, false, false, nil)
+	if err != nil {
+		logger.Warn("rabbitmq_inspect_failed", "queue", p.queueName, "error", err.Error())
+		return
+	}
+
+	p.mu.Lock()
+	p.queue = queueState{depth: q.Messages, consumers: q.Consumers, at: time.Now()}
+	p.mu.Unlock()
+	queueDepth.Set(float64(q.Messages))
+	queueConsumers.Set(float64(q.Consumers))
+}
+
+// setBlocked records a connection.blocked or connection.unblocked notification.
+func (p *Publisher) setBlocked(b amqp.Blocking) {
+	p.mu.Lock()
+	changed := p.blocked != b.Active
+	p.blocked, p.blockedReason = b.Active, b.Reason
+	p.mu.Unlock()
+
+	if b.Active {
+		rabbitmqBlocked.Set(1)
+	} else {
+		rabbitmqBlocked.Set(0)
+	}
+	switch {
+	case changed && b.Active:
+		logger.Warn("rabbitmq_connection_blocked", "queue", p.queueName, "reason", b.Reason)
+	case changed:
+		logger.Info("rabbitmq_connection_unblocked", "queue", p.queueName)
+	}
+}
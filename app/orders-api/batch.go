@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/praivan/orders-core/logging"
+	"github.com/praivan/orders-core/order"
+)
+
+// ---- Bulk upload ----
+//
+// POST /orders:batch accepts a JSON array of orders (application/json), one
+// order per line (application/x-ndjson) or a CSV file (text/csv). Records are
+// read as they arrive, validated, and stored batch.chunk_size at a time in one
+// transaction each, with the usual outbox row and order ID idempotency key;
+// the relay then publishes them with batched publisher confirms. The response
+// is NDJSON streamed back chunk by chunk: one line per record,
+//
+//	{"line":3,"order_id":"A-3","status":"accepted"}
+//
+// with status accepted, invalid or duplicate, and a final
+// {"summary":{...}} line. "line" is the line number for NDJSON and CSV (the
+// first line of the order) and the 1-based position in a JSON array. Errors
+// that end the upload early, e.g. a syntax error in the array or a lost
+// database, are reported in the summary; records reported before are stored.
+// Backpressure only stops an upload at its first new order: replays are
+// still reported, and an upload stopped before any report line gets a plain
+// 503 like POST /orders.
+
+const (
+	batchPath = "/orders:batch"
+
+	batchAccepted  = "accepted"
+	batchInvalid   = "invalid"
+	batchDuplicate = "duplicate"
+
+	// batchMaxLineBytes bounds one NDJSON line.
+	batchMaxLineBytes = 1 << 20
+)
+
+// csvColumns are the columns a CSV upload may have, named in its header row.
+// order_id is required. Each row with a sku is an order line; consecutive
+// rows with the same order_id make up one order, whose quantity and currency
+// are taken from its first row.
+var csvColumns = []string{"order_id", "quantity", "currency", "sku", "item_quantity", "unit_price_cents"}
+
+var (
+	errUnsupportedBatchType = errors.New("Content-Type must be application/json, application/x-ndjson or text/csv")
+
+	// errBatchStore hides database errors from clients.
+	errBatchStore = errors.New("store failed")
+)
+
+// batchResult is the report line of one record.
+type batchResult struct {
+	Line    int      `json:"line"`
+	OrderID string   `json:"order_id,omitempty"`
+	Status  string   `json:"status"`
+	Error   string   `json:"error,omitempty"`
+	Details []string `json:"details,omitempty"`
+}
+
+// batchSummary is the last report line.
+type batchSummary struct {
+	Records   int    `json:"records"`
+	Accepted  int    `json:"accepted"`
+	Invalid   int    `json:"invalid"`
+	Duplicate int    `json:"duplicate"`
+	Error     string `json:"error,omitempty"`
+}
+
+// batchRecord is one order read from an upload. err is set when the record
+// could not be decoded; it is reported as invalid and the upload goes on.
+type batchRecord struct {
+	line  int
+	order order.Order
+	err   error
+}
+
+// batchReader yields the records of an upload.
+type batchReader interface {
+	// Next returns the next record, or io.EOF after the last one. Any other
+	// error ends the upload.
+	Next() (batchRecord, error)
+}
+
+// newBatchReader picks the reader for the request's Content-Type.
+func newBatchReader(r *http.Request) (batchReader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		return newJSONArrayReader(r.Body)
+	case "application/x-ndjson", "application/jsonl":
+		return newNDJSONReader(r.Body), nil
+	case "text/csv":
+		return newCSVReader(r.Body)
+	}
+	return nil, errUnsupportedBatchType
+}
+
+// batchOrdersHandler serves POST /orders:batch.
+func batchOrdersHandler(cfg Config, pub *Publisher, relay *OutboxRelay) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logAndCount(w, r, "orders_batch", func(w http.ResponseWriter) (int, error) {
+			records, err := newBatchReader(r)
+			switch {
+			case errors.Is(err, errUnsupportedBatchType):
+				writeJSONError(w, http.StatusUnsupportedMediaType, err.Error())
+				return http.StatusUnsupportedMediaType, nil
+			case err != nil:
+				if bodyTooLarge(w, err) {
+					return http.StatusRequestEntityTooLarge, err
+				}
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return http.StatusBadRequest, nil
+			}
+
+			// Keep reading the upload after the report has started. HTTP/2
+			// always allows that; HTTP/1 needs to be told.
+			rc := http.NewResponseController(w)
+			_ = rc.EnableFullDuplex()
+
+			u := &batchUpload{
+				cfg:   cfg,
+				pub:   pub,
+				relay: relay,
+				w:     w,
+				enc:   json.NewEncoder(w),
+				rc:    rc,
+			}
+			err = u.run(r.Context(), records)
+			if !u.started && rejectSaturated(w, r, err, cfg.Publisher.Backpressure.RetryAfter) {
+				return http.StatusServiceUnavailable, nil
+			}
+			if u.started {
+				countRejection(err)
+			}
+			if err != nil {
+				u.summary.Error = batchErrorMessage(err)
+			}
+			u.start()
+			_ = u.enc.Encode(map[string]batchSummary{"summary": u.summary})
+
+			logger.InfoContext(r.Context(), "orders_batch_completed",
+				"records", u.summary.Records,
+				"accepted", u.summary.Accepted,
+				"invalid", u.summary.Invalid,
+				"duplicate", u.summary.Duplicate,
+			)
+			if errors.Is(err, errBatchStore) {
+				return http.StatusOK, err
+			}
+			if err != nil {
+				logger.WarnContext(r.Context(), "orders_batch_aborted", "error", err.Error())
+			}
+			return http.StatusOK, nil
+		})
+	}
+}
+
+// batchErrorMessage turns an error that ended an upload into the summary's
+// error.
+func batchErrorMessage(err error) string {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, errBatchStore):
+		return errBatchStore.Error()
+	case errors.Is(err, ErrBackpressure):
+		return "orders queue saturated, retry the remaining records later"
+	case errors.As(err, &tooLarge):
+		return fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)
+	}
+	return err.Error()
+}
+
+// batchUpload is the state of one POST /orders:batch request.
+type batchUpload struct {
+	cfg   Config
+	pub   *Publisher
+	relay *OutboxRelay
+	w     http.ResponseWriter
+	enc   *json.Encoder
+	rc    *http.ResponseController
+
+	// started is set once the 200 and the first report line went out.
+	started bool
+	read    int
+	summary batchSummary
+}
+
+// start sends the response header of the report.
+func (u *batchUpload) start() {
+	if u.started {
+		return
+	}
+	u.started = true
+	u.w.Header().Set("Content-Type", "application/x-ndjson")
+	u.w.WriteHeader(http.StatusOK)
+}
+
+// run stores and reports records chunk by chunk until the upload ends.
+func (u *batchUpload) run(ctx context.Context, records batchReader) error {
+	chunk := make([]batchRecord, 0, u.cfg.Batch.ChunkSize)
+	for {
+		rec, err := records.Next()
+		if errors.Is(err, io.EOF) {
+			return u.flush(ctx, chunk)
+		}
+		if err != nil {
+			if ferr := u.flush(ctx, chunk); ferr != nil {
+				return ferr
+			}
+			return err
+		}
+
+		if limit := u.cfg.Batch.MaxRecords; limit > 0 && u.read == limit {
+			if err := u.flush(ctx, chunk); err != nil {
+				return err
+			}
+			return fmt.Errorf("upload has more than %d records (batch.max_records)", limit)
+		}
+		u.read++
+		chunk = append(chunk, rec)
+		if len(chunk) < u.cfg.Batch.ChunkSize {
+			continue
+		}
+
+		if err := u.flush(ctx, chunk); err != nil {
+			return err
+		}
+		chunk = chunk[:0]
+	}
+}
+
+// flush validates and stores chunk and writes its report lines. When
+// backpressure stops it at a new order, only the records before it are
+// reported and Admit's error is returned.
+func (u *batchUpload) flush(ctx context.Context, chunk []batchRecord) error {
+	if len(chunk) == 0 {
+		return nil
+	}
+
+	results := make([]batchResult, len(chunk))
+	pending := 0
+	for i := range chunk {
+		rec := &chunk[i]
+		res := &results[i]
+		res.Line = rec.line
+		if rec.err != nil {
+			res.OrderID = rec.order.OrderID
+			res.Status, res.Error = batchInvalid, "invalid payload"
+			res.Details = []string{rec.err.Error()}
+			continue
+		}
+
+		rec.order.Normalize()
+		res.OrderID = rec.order.OrderID
+		var invalid *order.ValidationError
+		if err := rec.order.Validate(); errors.As(err, &invalid) {
+			res.Status, res.Error = batchInvalid, "invalid payload"
+			res.Details = invalid.Problems
+			continue
+		}
+		pending++
+	}
+
+	var saturated error
+	if pending > 0 {
+		n, err := u.store(ctx, chunk, results)
+		switch {
+		case errors.Is(err, ErrBackpressure):
+			saturated, results = err, results[:n]
+		case err != nil:
+			logger.ErrorContext(ctx, "orders_batch_store_failed",
+				"first_line", chunk[0].line,
+				"error", err.Error(),
+			)
+			return fmt.Errorf("%w: %w", errBatchStore, err)
+		}
+	}
+	if len(results) == 0 {
+		return saturated
+	}
+
+	u.start()
+	for _, res := range results {
+		switch res.Status {
+		case batchAccepted:
+			u.summary.Accepted++
+		case batchInvalid:
+			u.summary.Invalid++
+		case batchDuplicate:
+			u.summary.Duplicate++
+		}
+		u.summary.Records++
+		ordersBatchRecordsTotal.WithLabelValues(res.Status).Inc()
+		if err := u.enc.Encode(res); err != nil {
+			return err
+		}
+	}
+	if err := u.rc.Flush(); err != nil {
+		return err
+	}
+	return saturated
+}
+
+// store accepts the valid records of chunk, those without a status yet, in
+// one transaction and fills in their results. It returns how many records
+// were handled: all of them, or those before a new order that Admit
+// rejected, together with Admit's error.
+func (u *batchUpload) store(ctx context.Context, chunk []batchRecord, results []batchResult) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	handled := len(chunk)
+	var saturated error
+	accepted := 0
+	for i := range chunk {
+		res := &results[i]
+		if res.Status != "" {
+			continue
+		}
+		o := chunk[i].order
+		resp, err := acceptInTx(logging.WithOrderID(ctx, o.OrderID), tx, u.cfg.Idempotency.TTL, orderKey(o.OrderID), o, u.pub.Admit)
+		if errors.Is(err, ErrBackpressure) {
+			handled, saturated = i, err
+			break
+		}
+		switch {
+		case errors.Is(err, ErrIdempotencyMismatch):
+			res.Status, res.Error = batchDuplicate, "order_id already used with a different payload"
+		case errors.Is(err, ErrIdempotencyInProgress):
+			res.Status, res.Error = batchDuplicate, "order_id is being accepted by another request"
+		case errors.Is(err, ErrOrderExists):
+			res.Status, res.Error = batchDuplicate, ErrOrderExists.Error()
+		case err != nil:
+			return 0, err
+		case resp.Replayed:
+			res.Status = batchDuplicate
+			ordersIdempotentReplaysTotal.Inc()
+		default:
+			res.Status = batchAccepted
+			accepted++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if accepted > 0 {
+		u.relay.Notify()
+	}
+	return handled, saturated
+}
+
+// ---- Readers ----
+
+// jsonArrayReader streams the elements of a JSON array.
+type jsonArrayReader struct {
+	dec *json.Decoder
+	n   int
+}
+
+func newJSONArrayReader(body io.Reader) (*jsonArrayReader, error) {
+	dec := json.NewDecoder(body)
+	tok, err := dec.Token()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, err
+	}
+	if delim, ok := tok.(json.Delim); err != nil || !ok || delim != '[' {
+		return nil, errors.New("body must be a JSON array of orders")
+	}
+	return &jsonArrayReader{dec: dec}, nil
+}
+
+func (j *jsonArrayReader) Next() (batchRecord, error) {
+	if !j.dec.More() {
+		// The closing bracket, or the error that stopped More.
+		if _, err := j.dec.Token(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return batchRecord{}, fmt.Errorf("after record %d: %w", j.n, err)
+		}
+		return batchRecord{}, io.EOF
+	}
+
+	// Depending on the encoding/json version, a truncated or malformed array
+	// fails here rather than in Token above.
+	var raw json.RawMessage
+	if err := j.dec.Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return batchRecord{}, fmt.Errorf("after record %d: %w", j.n, err)
+	}
+	j.n++
+	rec := batchRecord{line: j.n}
+	rec.err = json.Unmarshal(raw, &rec.order)
+	return rec, nil
+}
+
+// ndjsonReader reads one JSON order per line; blank lines are skipped.
+type ndjsonReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func newNDJSONReader(body io.Reader) *ndjsonReader {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 64<<10), batchMaxLineBytes)
+	return &ndjsonReader{sc: sc}
+}
+
+func (n *ndjsonReader) Next() (batchRecord, error) {
+	for n.sc.Scan() {
+		n.line++
+		text := bytes.TrimSpace(n.sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		rec := batchRecord{line: n.line}
+		rec.err = json.Unmarshal(text, &rec.order)
+		return rec, nil
+	}
+	err := n.sc.Err()
+	switch {
+	case err == nil:
+		return batchRecord{}, io.EOF
+	case errors.Is(err, bufio.ErrTooLong):
+		return batchRecord{}, fmt.Errorf("line %d is longer than %d bytes", n.line+1, batchMaxLineBytes)
+	}
+	return batchRecord{}, err
+}
+
+// csvRow is one data row of a CSV upload.
+type csvRow struct {
+	line   int
+	fields []string
+	// err is a *csv.ParseError for a malformed row.
+	err error
+}
+
+// csvReader groups the rows of a CSV upload into orders.
+type csvReader struct {
+	r    *csv.Reader
+	cols map[string]int
+
+	// next is the row read past the end of the previous order.
+	next *csvRow
+	// err ends the upload once the order before it has been returned.
+	err error
+}
+
+func newCSVReader(body io.Reader) (*csvReader, error) {
+	r := csv.NewReader(body)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return nil, err
+	case err != nil:
+		return nil, errors.New("CSV upload needs a header row")
+	}
+
+	cols := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(csvColumns, name) {
+			return nil, fmt.Errorf("unknown CSV column %q; allowed are %s", name, strings.Join(csvColumns, ", "))
+		}
+		if _, dup := cols[name]; dup {
+			return nil, fmt.Errorf("duplicate CSV column %q", name)
+		}
+		cols[name] = i
+	}
+	if _, ok := cols["order_id"]; !ok {
+		return nil, errors.New("CSV header needs an order_id column")
+	}
+	return &csvReader{r: r, cols: cols}, nil
+}
+
+// row returns the lookahead row or reads the next one.
+func (c *csvReader) row() (*csvRow, error) {
+	if row := c.next; row != nil {
+		c.next = nil
+		return row, nil
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	fields, err := c.r.Read()
+	var parseErr *csv.ParseError
+	switch {
+	case errors.As(err, &parseErr):
+		return &csvRow{line: parseErr.StartLine, err: parseErr.Err}, nil
+	case err != nil:
+		c.err = err
+		return nil, err
+	}
+	line, _ := c.r.FieldPos(0)
+	return &csvRow{line: line, fields: fields}, nil
+}
+
+func (c *csvReader) field(row *csvRow, name string) string {
+	i, ok := c.cols[name]
+	if !ok || i >= len(row.fields) {
+		return ""
+	}
+	return strings.TrimSpace(row.fields[i])
+}
+
+func (c *csvReader) Next() (batchRecord, error) {
+	first, err := c.row()
+	if err != nil {
+		return batchRecord{}, err
+	}
+	rec := batchRecord{line: first.line}
+	if first.err != nil {
+		rec.err = fmt.Errorf("line %d: %w", first.line, first.err)
+		return rec, nil
+	}
+
+	rec.order.OrderID = c.field(first, "order_id")
+	rec.order.Currency = c.field(first, "currency")
+	c.parseInt(&rec, first, "quantity", func(n int64) { rec.order.Quantity = int(n) })
+	c.addItem(&rec, first)
+
+	for {
+		row, err := c.row()
+		if err != nil {
+			// Reported with the next call, after this order.
+			return rec, nil
+		}
+		if row.err != nil || c.field(row, "order_id") != rec.order.OrderID {
+			c.next = row
+			return rec, nil
+		}
+		c.addItem(&rec, row)
+	}
+}
+
+// addItem appends the order line of row, if it has a sku. Items beyond
+// order.MaxItems are dropped; validation already rejects the order.
+func (c *csvReader) addItem(rec *batchRecord, row *csvRow) {
+	sku := c.field(row, "sku")
+	if sku == "" || len(rec.order.Items) > order.MaxItems {
+		return
+	}
+	it := order.Item{SKU: sku}
+	c.parseInt(rec, row, "item_quantity", func(n int64) { it.Quantity = int(n) })
+	c.parseInt(rec, row, "unit_price_cents", func(n int64) { it.UnitPriceCents = n })
+	rec.order.Items = append(rec.order.Items, it)
+}
+
+// parseInt passes the integer in column name of row to set, if present, or
+// records the first malformed value as rec.err.
+func (c *csvReader) parseInt(rec *batchRecord, row *csvRow, name string, set func(int64)) {
+	s := c.field(row, name)
+	if s == "" {
+		return
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		if rec.err == nil {
+			rec.err = fmt.Errorf("line %d: %s must be an integer, got %q", row.line, name, s)
+		}
+		return
+	}
+	set(n)
+}
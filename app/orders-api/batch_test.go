@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/praivan/orders-core/order"
+)
+
+// readRecords drains r and summarizes each record as
+// "<line> <order_id> <quantity> <skus>" or "<line> error: <err>".
+func readRecords(r batchReader) ([]string, error) {
+	var out []string
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+		if rec.err != nil {
+			out = append(out, fmt.Sprintf("%d error: %v", rec.line, rec.err))
+			continue
+		}
+		var skus []string
+		for _, it := range rec.order.Items {
+			skus = append(skus, fmt.Sprintf("%s*%d@%d", it.SKU, it.Quantity, it.UnitPriceCents))
+		}
+		out = append(out, fmt.Sprintf("%d %s %d %s", rec.line, rec.order.OrderID, rec.order.Quantity, strings.Join(skus, ",")))
+	}
+}
+
+func TestJSONArrayReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr string // error of the constructor or of Next
+	}{
+		{name: "empty array", body: ` [ ] `},
+		{
+			name: "orders",
+			body: `[{"order_id":"a","quantity":2},
+				{"order_id":"b","currency":"EUR","items":[{"sku":"X","quantity":1,"unit_price_cents":5}]}]`,
+			want: []string{"1 a 2 ", "2 b 0 X*1@5"},
+		},
+		{
+			name: "undecodable record is reported and skipped",
+			body: `[{"order_id":"a"},{"order_id":7},{"order_id":"c"}]`,
+			want: []string{"1 a 0 ", "2 error: json: cannot unmarshal number into Go struct field Order.order_id of type string", "3 c 0 "},
+		},
+		{name: "not an array", body: `{"order_id":"a"}`, wantErr: "body must be a JSON array of orders"},
+		{name: "empty body", body: ``, wantErr: "body must be a JSON array of orders"},
+		{
+			name:    "truncated",
+			body:    `[{"order_id":"a"},`,
+			want:    []string{"1 a 0 "},
+			wantErr: "after record 1: unexpected",
+		},
+		{
+			name:    "missing closing bracket",
+			body:    `[{"order_id":"a"}`,
+			want:    []string{"1 a 0 "},
+			wantErr: "after record 1: unexpected",
+		},
+		{
+			name:    "syntax error",
+			body:    `[{"order_id":"a"} {"order_id":"b"}]`,
+			want:    []string{"1 a 0 "},
+			wantErr: "after record 1: invalid character",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			r, err := newJSONArrayReader(strings.NewReader(tt.body))
+			if err == nil {
+				got, err = readRecords(r)
+			}
+			checkRecords(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestNDJSONReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr string
+	}{
+		{name: "empty", body: ""},
+		{
+			name: "blank lines keep line numbers",
+			body: "{\"order_id\":\"a\"}\n\n  \r\n{\"order_id\":\"b\",\"quantity\":3}\r\n",
+			want: []string{"1 a 0 ", "4 b 3 "},
+		},
+		{
+			name: "bad line is reported and skipped",
+			body: "{\"order_id\":\"a\"}\nnot json\n{\"order_id\":\"c\"}",
+			want: []string{"1 a 0 ", "2 error: invalid character 'o' in literal null (expecting 'u')", "3 c 0 "},
+		},
+		{
+			name:    "line too long",
+			body:    "{\"order_id\":\"a\"}\n" + strings.Repeat(" ", batchMaxLineBytes+1) + "\n",
+			want:    []string{"1 a 0 "},
+			wantErr: fmt.Sprintf("line 2 is longer than %d bytes", batchMaxLineBytes),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readRecords(newNDJSONReader(strings.NewReader(tt.body)))
+			checkRecords(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestCSVReader(t *testing.T) {
+	var tooMany strings.Builder
+	tooMany.WriteString("order_id,currency,sku,item_quantity\n")
+	for i := 0; i < order.MaxItems+5; i++ {
+		fmt.Fprintf(&tooMany, "big,EUR,S%d,1\n", i)
+	}
+	tooMany.WriteString("next,EUR,S,1\n")
+
+	tests := []struct {
+		name    string
+		body    string
+		want    []string
+		wantErr string
+	}{
+		{name: "header only", body: "order_id\n"},
+		{
+			name: "one row per order",
+			body: "order_id,quantity\na,1\nb,2\n",
+			want: []string{"2 a 1 ", "3 b 2 "},
+		},
+		{
+			name: "consecutive rows of an order are grouped",
+			body: "Order_ID, currency, sku, item_quantity, unit_price_cents\n" +
+				"a,EUR,X,1,100\n" +
+				"a,,Y,2,250\n" +
+				"b,EUR,Z,3,10\n" +
+				"a,EUR,X,1,100\n",
+			want: []string{"2 a 0 X*1@100,Y*2@250", "4 b 0 Z*3@10", "5 a 0 X*1@100"},
+		},
+		{
+			name: "columns in any order, missing ones empty",
+			body: "sku,order_id\nX,a\n,b\n",
+			want: []string{"2 a 0 X*0@0", "3 b 0 "},
+		},
+		{
+			name: "bad integer",
+			body: "order_id,sku,item_quantity,unit_price_cents\na,X,two,1\na,Y,1,1.5\nb,Z,1,1\n",
+			want: []string{`2 error: line 2: item_quantity must be an integer, got "two"`, "4 b 0 Z*1@1"},
+		},
+		{
+			name: "malformed row",
+			body: "order_id,sku\na,X\na,\"Y\nb,Z\n",
+			want: []string{"2 a 0 X*0@0", `3 error: line 3: extraneous or missing " in quoted-field`},
+		},
+		{
+			name: "wrong number of fields",
+			body: "order_id,sku\na,X\nb\nc,Z\n",
+			want: []string{"2 a 0 X*0@0", "3 error: line 3: wrong number of fields", "4 c 0 Z*0@0"},
+		},
+		{
+			name: "items beyond MaxItems are dropped",
+			body: tooMany.String(),
+			want: []string{
+				fmt.Sprintf("2 big 0 %s", strings.Join(func() []string {
+					var s []string
+					for i := 0; i <= order.MaxItems; i++ {
+						s = append(s, fmt.Sprintf("S%d*1@0", i))
+					}
+					return s
+				}(), ",")),
+				fmt.Sprintf("%d next 0 S*1@0", order.MaxItems+7),
+			},
+		},
+		{name: "no header", body: "", wantErr: "CSV upload needs a header row"},
+		{name: "unknown column", body: "order_id,price\n", wantErr: `unknown CSV column "price"`},
+		{name: "duplicate column", body: "order_id,SKU,sku\n", wantErr: `duplicate CSV column "sku"`},
+		{name: "no order_id column", body: "sku,quantity\n", wantErr: "CSV header needs an order_id column"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			r, err := newCSVReader(strings.NewReader(tt.body))
+			if err == nil {
+				got, err = readRecords(r)
+			}
+			checkRecords(t, got, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func checkRecords(t *testing.T, got []string, err error, want []string, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case wantErr != "" && (err == nil || !strings.Contains(err.Error(), wantErr)):
+		t.Fatalf("error = %v, want %q", err, wantErr)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("records =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestNewBatchReader(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantErr     error
+	}{
+		{"application/json", nil},
+		{"application/json; charset=utf-8", nil},
+		{"application/x-ndjson", nil},
+		{"application/jsonl", nil},
+		{"text/csv", nil},
+		{"text/plain", errUnsupportedBatchType},
+		{"", errUnsupportedBatchType},
+	}
+	for _, tt := range tests {
+		body := "[]"
+		if tt.contentType == "text/csv" {
+			body = "order_id\n"
+		}
+		r := httptest.NewRequest(http.MethodPost, "/orders:batch", strings.NewReader(body))
+		r.Header.Set("Content-Type", tt.contentType)
+		if _, err := newBatchReader(r); !errors.Is(err, tt.wantErr) {
+			t.Errorf("newBatchReader(%q) error = %v, want %v", tt.contentType, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/praivan/orders-core/logging"
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/principal"
+	"github.com/praivan/orders-core/store"
+)
+
+// ---- Cancellation ----
+//
+// DELETE /orders/{id} and POST /orders/{id}/cancel enqueue an
+// order.cancel_requested command through the outbox; orders-worker applies
+// it. Cancelling an order whose create message has not been processed yet
+// makes the worker skip it when it arrives.
+
+const maxCancelReasonLen = 500
+
+// ErrAlreadyCancelled is returned for repeated cancellations.
+var ErrAlreadyCancelled = errors.New("order already cancelled")
+
+// requestCancellation enqueues a cancellation for an existing order.
+func requestCancellation(ctx context.Context, c order.Cancellation) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status, err := store.LockStatus(ctx, tx, c.OrderID)
+	if err != nil {
+		return err
+	}
+	if status == order.StatusCancelled {
+		return ErrAlreadyCancelled
+	}
+
+	if err := enqueueCancellation(ctx, tx, c); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// cancelOrderHandler serves both cancellation routes. POST may carry an
+// optional {"reason": "..."} body.
+func cancelOrderHandler(relay *OutboxRelay) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(logging.WithOrderID(r.Context(), r.PathValue("id")))
+		logAndCount(w, r, "orders_cancel", func(w http.ResponseWriter) (int, error) {
+			c := order.Cancellation{
+				OrderID:     r.PathValue("id"),
+				RequestedBy: principal.FromContext(r.Context()),
+			}
+			w.Header().Set("Content-Type", "application/json")
+
+			if r.Method == http.MethodPost {
+				var body struct {
+					Reason string `json:"reason"`
+				}
+				err := json.NewDecoder(r.Body).Decode(&body)
+				if bodyTooLarge(w, err) {
+					return http.StatusRequestEntityTooLarge, err
+				}
+				if err != nil && !errors.Is(err, io.EOF) {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"error":"invalid payload"}`))
+					return http.StatusBadRequest, err
+				}
+				if len(body.Reason) > maxCancelReasonLen {
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"error":"reason too long"}`))
+					return http.StatusBadRequest, errors.New("cancel reason too long")
+				}
+				c.Reason = body.Reason
+			}
+
+			err := requestCancellation(r.Context(), c)
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"order not found"}`))
+				return http.StatusNotFound, nil
+			case errors.Is(err, ErrAlreadyCancelled):
+				w.WriteHeader(http.StatusOK)
+				_ = json.NewEncoder(w).Encode(map[string]string{
+					"status":   string(order.StatusCancelled),
+					"order_id": c.OrderID,
+				})
+				return http.StatusOK, nil
+			case err != nil:
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"error":"store failed"}`))
+				logger.ErrorContext(r.Context(), "order_cancel_failed", "error", err.Error())
+				return http.StatusInternalServerError, err
+			}
+
+			relay.Notify()
+			logger.InfoContext(r.Context(), "order_cancel_requested", "reason", c.Reason)
+
+			w.Header().Set("Location", orderURL(c.OrderID))
+			w.WriteHeader(http.StatusAccepted)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"status":   "cancel_requested",
+				"order_id": c.OrderID,
+			})
+			return http.StatusAccepted, nil
+		})
+	}
+}
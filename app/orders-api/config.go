@@ -0,0 +1,269 @@
+package main
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/praivan/orders-core/config"
+	"github.com/praivan/orders-core/topology"
+)
+
+// ---- Configuration ----
+//
+// Loaded once at startup by config.Load: defaults below, then CONFIG_FILE,
+// then the environment variables named in the tags (each also accepted as
+// <VAR>_FILE). GET /admin/config on the admin listener shows the result.
+
+type Config struct {
+	HTTP        HTTPConfig        `yaml:"http"`
+	Auth        AuthConfig        `yaml:"auth"`
+	RateLimit   RateLimitConfig   `yaml:"rate_limit"`
+	Postgres    config.Postgres   `yaml:"postgres"`
+	RabbitMQ    config.RabbitMQ   `yaml:"rabbitmq"`
+	Publisher   PublisherConfig   `yaml:"publisher"`
+	Outbox      OutboxConfig      `yaml:"outbox"`
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+	Batch       BatchConfig       `yaml:"batch"`
+	List        ListConfig        `yaml:"list"`
+	Shutdown    ShutdownConfig    `yaml:"shutdown"`
+}
+
+type HTTPConfig struct {
+	Addr string `yaml:"addr" env:"HTTP_ADDR"`
+	// AdminAddr serves /admin/config; it is not exposed through a Service.
+	AdminAddr string `yaml:"admin_addr" env:"ADMIN_ADDR"`
+	// MaxBodyBytes bounds request bodies; larger ones get 413.
+	MaxBodyBytes int `yaml:"max_body_bytes" env:"HTTP_MAX_BODY_BYTES"`
+	// TrustedProxies lists comma separated CIDRs or IPs whose
+	// X-Forwarded-For header is used to find the client address.
+	TrustedProxies string `yaml:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES"`
+}
+
+// RateLimitConfig sets the token bucket each client gets (see limits.go).
+type RateLimitConfig struct {
+	// RPS is the sustained rate per client; 0 turns rate limiting off.
+	RPS   float64 `yaml:"rps" env:"RATE_LIMIT_RPS"`
+	Burst int     `yaml:"burst" env:"RATE_LIMIT_BURST"`
+	// AuthFailureRPS is the sustained rate of failed authentication attempts
+	// per client IP; 0 turns the check off.
+	AuthFailureRPS   float64 `yaml:"auth_failure_rps" env:"RATE_LIMIT_AUTH_FAILURE_RPS"`
+	AuthFailureBurst int     `yaml:"auth_failure_burst" env:"RATE_LIMIT_AUTH_FAILURE_BURST"`
+}
+
+// AuthConfig selects how callers authenticate (see auth.go). At least one of
+// API keys and JWT must be configured unless Disabled is set.
+type AuthConfig struct {
+	// Disabled serves every request as "anonymous" with all scopes; only
+	// meant for local development.
+	Disabled bool `yaml:"disabled" env:"AUTH_DISABLED"`
+	// APIKeys lists "<name> <sha256 hex of key> <scope>[,<scope>...]"
+	// entries separated by newlines or semicolons.
+	APIKeys config.Secret `yaml:"api_keys" env:"AUTH_API_KEYS"`
+	JWT     JWTConfig     `yaml:"jwt"`
+}
+
+// JWTConfig validates bearer tokens against a JWKS given inline (usually
+// via AUTH_JWKS_FILE) or fetched from JWKSURL every JWKSRefresh.
+type JWTConfig struct {
+	JWKS        string        `yaml:"jwks" env:"AUTH_JWKS"`
+	JWKSURL     string        `yaml:"jwks_url" env:"AUTH_JWKS_URL"`
+	JWKSRefresh time.Duration `yaml:"jwks_refresh" env:"AUTH_JWKS_REFRESH"`
+	Issuer      string        `yaml:"issuer" env:"AUTH_JWT_ISSUER"`
+	Audience    string        `yaml:"audience" env:"AUTH_JWT_AUDIENCE"`
+}
+
+// Enabled reports whether JWT authentication is configured.
+func (c JWTConfig) Enabled() bool {
+	return c.JWKS != "" || c.JWKSURL != ""
+}
+
+type PublisherConfig struct {
+	// Wait bounds how long Publish waits for a healthy channel.
+	Wait time.Duration `yaml:"wait" env:"PUBLISH_WAIT"`
+	// ConfirmWait bounds how long Publish waits for the broker's confirm.
+	ConfirmWait  time.Duration      `yaml:"confirm_wait" env:"PUBLISH_CONFIRM_WAIT"`
+	Backpressure BackpressureConfig `yaml:"backpressure"`
+}
+
+// BackpressureConfig decides when POST /orders is rejected because
+// orders-worker is not keeping up (see backpressure.go).
+type BackpressureConfig struct {
+	// Interval is how often the orders queue is inspected.
+	Interval time.Duration `yaml:"interval" env:"BACKPRESSURE_INTERVAL"`
+	// MaxDepth is the number of ready messages above which new orders are
+	// rejected; 0 disables the check.
+	MaxDepth int `yaml:"max_depth" env:"BACKPRESSURE_MAX_DEPTH"`
+	// RequireConsumers rejects new orders while the queue has no consumer.
+	RequireConsumers bool `yaml:"require_consumers" env:"BACKPRESSURE_REQUIRE_CONSUMERS"`
+	// RetryAfter is sent to rejected clients.
+	RetryAfter time.Duration `yaml:"retry_after" env:"BACKPRESSURE_RETRY_AFTER"`
+}
+
+type OutboxConfig struct {
+	PollInterval time.Duration `yaml:"poll_interval" env:"OUTBOX_POLL_INTERVAL"`
+	BatchSize    int           `yaml:"batch_size" env:"OUTBOX_BATCH_SIZE"`
+	// Retention is how long sent rows are kept before being purged.
+	Retention time.Duration `yaml:"retention" env:"OUTBOX_RETENTION"`
+}
+
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"IDEMPOTENCY_TTL"`
+}
+
+// BatchConfig limits POST /orders:batch uploads (see batch.go).
+type BatchConfig struct {
+	// MaxBodyBytes replaces http.max_body_bytes for uploads.
+	MaxBodyBytes int `yaml:"max_body_bytes" env:"BATCH_MAX_BODY_BYTES"`
+	// MaxRecords caps the records of one upload; 0 means no cap.
+	MaxRecords int `yaml:"max_records" env:"BATCH_MAX_RECORDS"`
+	// ChunkSize is how many records are stored per transaction and
+	// reported to the client at once.
+	ChunkSize int `yaml:"chunk_size" env:"BATCH_CHUNK_SIZE"`
+}
+
+type ListConfig struct {
+	DefaultLimit int `yaml:"default_limit" env:"LIST_DEFAULT_LIMIT"`
+	MaxLimit     int `yaml:"max_limit" env:"LIST_MAX_LIMIT"`
+}
+
+type ShutdownConfig struct {
+	Timeout    time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT"`
+	DrainDelay time.Duration `yaml:"drain_delay" env:"SHUTDOWN_DRAIN_DELAY"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		HTTP:      HTTPConfig{Addr: ":8080", AdminAddr: ":8081", MaxBodyBytes: 1 << 20},
+		Auth:      AuthConfig{JWT: JWTConfig{JWKSRefresh: 5 * time.Minute}},
+		RateLimit: RateLimitConfig{RPS: 10, Burst: 20, AuthFailureRPS: 0.2, AuthFailureBurst: 10},
+		RabbitMQ:  config.RabbitMQ{Queue: topology.OrdersQueue},
+		Publisher: PublisherConfig{
+			Wait:        5 * time.Second,
+			ConfirmWait: 5 * time.Second,
+			Backpressure: BackpressureConfig{
+				Interval:         5 * time.Second,
+				MaxDepth:         10000,
+				RequireConsumers: true,
+				RetryAfter:       30 * time.Second,
+			},
+		},
+		Outbox:      OutboxConfig{PollInterval: time.Second, BatchSize: 100, Retention: 24 * time.Hour},
+		Idempotency: IdempotencyConfig{TTL: 24 * time.Hour},
+		Batch:       BatchConfig{MaxBodyBytes: 64 << 20, MaxRecords: 100000, ChunkSize: 500},
+		List:        ListConfig{DefaultLimit: 50, MaxLimit: 500},
+		Shutdown:    ShutdownConfig{Timeout: 20 * time.Second, DrainDelay: 5 * time.Second},
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var p config.Problems
+	c.Postgres.Validate(&p)
+	c.RabbitMQ.Validate(&p)
+
+	if c.HTTP.Addr == "" {
+		p.Addf("http.addr must not be empty")
+	}
+	if c.HTTP.AdminAddr == c.HTTP.Addr {
+		p.Addf("http.admin_addr must differ from http.addr")
+	}
+	if c.HTTP.MaxBodyBytes < 1 {
+		p.Addf("http.max_body_bytes must be at least 1")
+	}
+	if _, err := parseTrustedProxies(c.HTTP.TrustedProxies); err != nil {
+		p.Addf("http.trusted_proxies: %v", err)
+	}
+	c.Auth.Validate(&p)
+	if c.RateLimit.RPS < 0 {
+		p.Addf("rate_limit.rps must not be negative")
+	}
+	if c.RateLimit.RPS > 0 && c.RateLimit.Burst < 1 {
+		p.Addf("rate_limit.burst must be at least 1")
+	}
+	if c.RateLimit.AuthFailureRPS < 0 {
+		p.Addf("rate_limit.auth_failure_rps must not be negative")
+	}
+	if c.RateLimit.AuthFailureRPS > 0 && c.RateLimit.AuthFailureBurst < 1 {
+		p.Addf("rate_limit.auth_failure_burst must be at least 1")
+	}
+	p.Positive("publisher.wait", c.Publisher.Wait)
+	p.Positive("publisher.confirm_wait", c.Publisher.ConfirmWait)
+	p.Positive("publisher.backpressure.interval", c.Publisher.Backpressure.Interval)
+	if c.Publisher.Backpressure.MaxDepth < 0 {
+		p.Addf("publisher.backpressure.max_depth must not be negative")
+	}
+	p.Positive("publisher.backpressure.retry_after", c.Publisher.Backpressure.RetryAfter)
+	p.Positive("outbox.poll_interval", c.Outbox.PollInterval)
+	p.Positive("outbox.retention", c.Outbox.Retention)
+	if c.Outbox.BatchSize < 1 {
+		p.Addf("outbox.batch_size must be at least 1")
+	}
+	p.Positive("idempotency.ttl", c.Idempotency.TTL)
+	if c.Batch.MaxBodyBytes < 1 {
+		p.Addf("batch.max_body_bytes must be at least 1")
+	}
+	if c.Batch.MaxRecords < 0 {
+		p.Addf("batch.max_records must not be negative")
+	}
+	if c.Batch.ChunkSize < 1 {
+		p.Addf("batch.chunk_size must be at least 1")
+	}
+	if c.List.MaxLimit < 1 {
+		p.Addf("list.max_limit must be at least 1")
+	}
+	if c.List.DefaultLimit < 1 || c.List.DefaultLimit > c.List.MaxLimit {
+		p.Addf("list.default_limit must be between 1 and list.max_limit (%d)", c.List.MaxLimit)
+	}
+	p.Positive("shutdown.timeout", c.Shutdown.Timeout)
+	if c.Shutdown.DrainDelay < 0 {
+		p.Addf("shutdown.drain_delay must not be negative")
+	}
+	return p.Err()
+}
+
+// Validate checks that some authentication is configured and that API key
+// entries and JWT settings are well-formed.
+func (a AuthConfig) Validate(p *config.Problems) {
+	if !a.Disabled && a.APIKeys == "" && !a.JWT.Enabled() {
+		p.Addf("auth: configure auth.api_keys and/or auth.jwt, or set auth.disabled")
+	}
+	if _, err := parseAPIKeys(string(a.APIKeys)); err != nil {
+		p.Addf("auth.api_keys: %v", err)
+	}
+	if !a.JWT.Enabled() {
+		return
+	}
+	if a.JWT.JWKS != "" && a.JWT.JWKSURL != "" {
+		p.Addf("auth.jwt: set only one of jwks and jwks_url")
+	}
+	if a.JWT.JWKS != "" {
+		if _, err := parseJWKS([]byte(a.JWT.JWKS)); err != nil {
+			p.Addf("auth.jwt.jwks: %v", err)
+		}
+	}
+	if a.JWT.JWKSURL != "" {
+		if u, err := url.Parse(a.JWT.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			p.Addf("auth.jwt.jwks_url must be an http(s) URL")
+		}
+		p.Positive("auth.jwt.jwks_refresh", a.JWT.JWKSRefresh)
+	}
+	if a.JWT.Issuer == "" {
+		p.Addf("auth.jwt.issuer is required with JWT authentication")
+	}
+	if a.JWT.Audience == "" {
+		p.Addf("auth.jwt.audience is required with JWT authentication")
+	}
+}
+
+// loadConfig loads and validates the configuration or exits.
+func loadConfig() Config {
+	cfg := defaultConfig()
+	err := config.Load(&cfg)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		logger.Fatal("config_invalid", "error", err.Error())
+	}
+	return cfg
+}
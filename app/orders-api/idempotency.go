@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/principal"
+	"github.com/praivan/orders-core/store"
+)
+
+// ---- Idempotency keys ----
+//
+// Every accepted POST /orders, and every record of POST /orders:batch, stores
+// its key, a hash of the request and the response that was sent. Repeats with
+// the same payload get the stored response replayed instead of a second
+// outbox row.
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyKeyMaxLen = 255
+	idempotencyPurge     = 10 * time.Minute
+)
+
+var (
+	// ErrIdempotencyMismatch is returned when a key is reused with a different payload.
+	ErrIdempotencyMismatch = errors.New("idempotency key reused with a different payload")
+
+	// ErrIdempotencyInProgress is returned when the original request has not finished yet.
+	ErrIdempotencyInProgress = errors.New("idempotency key is still being processed")
+
+	// ErrOrderExists is returned when the order ID was already accepted under
+	// another idempotency key. Enqueueing it again would let orders-worker
+	// overwrite the first order with this payload.
+	ErrOrderExists = errors.New("order_id already exists")
+)
+
+// storedResponse is the response recorded for an idempotency key.
+type storedResponse struct {
+	Code     int
+	Body     string
+	Replayed bool
+}
+
+// idempotencyKey returns the client supplied key, falling back to the order ID.
+// The two sources are namespaced so they never collide, and client keys are
+// scoped to the principal so two callers picking the same key do not see each
+// other's responses.
+func idempotencyKey(r *http.Request, o order.Order) (string, bool) {
+	if k := r.Header.Get(idempotencyHeader); k != "" {
+		if len(k) > idempotencyKeyMaxLen {
+			return "", false
+		}
+		return "key:" + principal.FromContext(r.Context()) + ":" + k, true
+	}
+	return orderKey(o.OrderID), true
+}
+
+// orderKey is the idempotency key of an order submitted without a client key,
+// including every record of POST /orders:batch.
+func orderKey(orderID string) string {
+	return "order:" + orderID
+}
+
+func requestHash(o order.Order) (string, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// acceptOrder records the idempotency key, kept for ttl, and enqueues the
+// order in a single transaction, or returns the previously stored response
+// for a repeat. admit (Publisher.Admit) is only consulted for new orders, so
+// retries of accepted requests get their response even under backpressure;
+// its error is returned as is.
+func acceptOrder(ctx context.Context, ttl time.Duration, key string, o order.Order, admit func() error) (storedResponse, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return storedResponse{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	resp, err := acceptInTx(ctx, tx, ttl, key, o, admit)
+	if err != nil {
+		return storedResponse{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return storedResponse{}, err
+	}
+	return resp, nil
+}
+
+// acceptInTx does the work of acceptOrder within tx, so POST /orders:batch
+// can accept many orders per transaction. ErrIdempotencyMismatch,
+// ErrIdempotencyInProgress, ErrOrderExists and admit's error leave tx usable
+// and unchanged.
+func acceptInTx(ctx context.Context, tx *sql.Tx, ttl time.Duration, key string, o order.Order, admit func() error) (storedResponse, error) {
+	hash, err := requestHash(o)
+	if err != nil {
+		return storedResponse{}, err
+	}
+
+	// Concurrent requests with the same key block here until the first commits.
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO idempotency_keys (key, request_hash, expires_at)
+		VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (key) DO NOTHING
+	`, key, hash, ttl.Seconds())
+	if err != nil {
+		return storedResponse{}, err
+	}
+
+	n, _ := res.RowsAffected()
+	fresh := n == 1
+	if !fresh {
+		var (
+			storedHash string
+			code       sql.NullInt64
+			body       sql.NullString
+			expired    bool
+		)
+		err := tx.QueryRowContext(ctx, `
+			SELECT request_hash, status_code, response_body, expires_at <= now()
+			FROM idempotency_keys
+			WHERE key = $1
+			FOR UPDATE
+		`, key).Scan(&storedHash, &code, &body, &expired)
+		if err != nil {
+			return storedResponse{}, err
+		}
+
+		if !expired {
+			switch {
+			case storedHash != hash:
+				return storedResponse{}, ErrIdempotencyMismatch
+			case !code.Valid:
+				return storedResponse{}, ErrIdempotencyInProgress
+			}
+			return storedResponse{Code: int(code.Int64), Body: body.String, Replayed: true}, nil
+		}
+		// The old key has expired: treat this as a brand new request. The
+		// row stays locked, so it is only rewritten once the order is in.
+	}
+
+	// reject drops a key inserted above, so the order can be retried.
+	reject := func(reason error) (storedResponse, error) {
+		if fresh {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1`, key); err != nil {
+				return storedResponse{}, err
+			}
+		}
+		return storedResponse{}, reason
+	}
+	if admit != nil {
+		if err := admit(); err != nil {
+			return reject(err)
+		}
+	}
+
+	// The order is visible as accepted right away; orders-worker moves it on.
+	inserted, err := store.InsertAccepted(ctx, tx, o)
+	if err != nil {
+		return storedResponse{}, err
+	}
+	if !inserted {
+		return reject(ErrOrderExists)
+	}
+	if !fresh {
+		_, err = tx.ExecContext(ctx, `
+			UPDATE idempotency_keys
+			SET request_hash = $2, status_code = NULL, response_body = NULL,
+			    created_at = now(), expires_at = now() + make_interval(secs => $3)
+			WHERE key = $1
+		`, key, hash, ttl.Seconds())
+		if err != nil {
+			return storedResponse{}, err
+		}
+	}
+	if err := enqueueOrder(ctx, tx, o); err != nil {
+		return storedResponse{}, err
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"status":   string(order.StatusAccepted),
+		"order_id": o.OrderID,
+	})
+	if err != nil {
+		return storedResponse{}, err
+	}
+	resp := storedResponse{Code: http.StatusAccepted, Body: string(body)}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE idempotency_keys SET status_code = $2, response_body = $3 WHERE key = $1
+	`, key, resp.Code, resp.Body)
+	if err != nil {
+		return storedResponse{}, err
+	}
+	return resp, nil
+}
+
+// runIdempotencyJanitor deletes expired keys until ctx is cancelled.
+func runIdempotencyJanitor(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPurge)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		res, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= now()`)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Error("idempotency_purge_failed", "error", err.Error())
+			}
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			logger.Info("idempotency_purged", "rows", n)
+		}
+	}
+}
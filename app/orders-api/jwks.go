@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---- JWKS ----
+//
+// The keys JWTs are verified with: parsed once from auth.jwt.jwks (a mounted
+// file via AUTH_JWKS_FILE, e.g. the one `orders-api auth dev-jwks` writes) or
+// fetched from auth.jwt.jwks_url at startup, every jwks_refresh, and when a
+// token names an unknown key ID (at most once per jwksMinRefresh).
+
+// jwtAlgorithms lists the JWS algorithms tokens may be signed with.
+var jwtAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+const (
+	jwksFetchTimeout = 5 * time.Second
+	jwksMinRefresh   = time.Minute
+	jwksMaxBytes     = 1 << 20
+)
+
+// signingKey is one usable public key of a JWKS.
+type signingKey struct {
+	key crypto.PublicKey
+	// alg is the key's "alg" parameter; "" allows every algorithm of its type.
+	alg string
+}
+
+// jwk holds the JWK parameters of the RSA and EC keys we accept.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// parseJWKS returns the signature keys of a JWK set by key ID. Encryption
+// keys and key types other than RSA and EC are skipped.
+func parseJWKS(data []byte) (map[string]signingKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	keys := map[string]signingKey{}
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %d (kid %q): %w", i, k.Kid, err)
+		}
+		if _, dup := keys[k.Kid]; dup {
+			return nil, fmt.Errorf("JWKS: duplicate kid %q", k.Kid)
+		}
+		keys[k.Kid] = signingKey{key: key, alg: k.Alg}
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS has no RSA or EC signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("n: %w", err)
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("e: %w", err)
+	}
+	if n.BitLen() < 2048 {
+		return nil, fmt.Errorf("RSA key is %d bits, need at least 2048", n.BitLen())
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("unsupported RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	curve, ok := curves[k.Crv]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("x: %w", err)
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("y: %w", err)
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	if _, err := key.ECDH(); err != nil {
+		return nil, fmt.Errorf("invalid EC point: %w", err)
+	}
+	return key, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("missing")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid base64url")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// algorithmOK reports whether a token signed with alg may be verified with k.
+func (k signingKey) algorithmOK(alg string) bool {
+	if k.alg != "" && k.alg != alg {
+		return false
+	}
+	switch key := k.key.(type) {
+	case *rsa.PublicKey:
+		switch alg {
+		case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+			return true
+		}
+	case *ecdsa.PublicKey:
+		// Each ECDSA algorithm is bound to one curve.
+		switch key.Curve.Params().Name {
+		case "P-256":
+			return alg == "ES256"
+		case "P-384":
+			return alg == "ES384"
+		case "P-521":
+			return alg == "ES512"
+		}
+	}
+	return false
+}
+
+// keySet holds the current JWKS.
+type keySet struct {
+	url    string
+	client *http.Client
+
+	mu      sync.RWMutex
+	keys    map[string]signingKey
+	fetched time.Time
+}
+
+// newKeySet parses the inline JWKS of cfg or fetches it from its URL.
+func newKeySet(cfg JWTConfig) (*keySet, error) {
+	s := &keySet{url: cfg.JWKSURL, client: &http.Client{Timeout: jwksFetchTimeout}}
+	if s.url == "" {
+		keys, err := parseJWKS([]byte(cfg.JWKS))
+		if err != nil {
+			return nil, err
+		}
+		s.keys = keys
+		return s, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+	if err := s.refresh(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// lookup returns the key for kid, refetching the JWKS once if kid is
+// unknown. Tokens without kid are accepted when the set has a single key.
+func (s *keySet) lookup(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	k, ok := s.find(kid)
+	if !ok && s.url != "" {
+		if s.startRefresh() {
+			if err := s.refresh(ctx); err != nil {
+				logger.WarnContext(ctx, "jwks_refresh_failed", "error", err.Error())
+			}
+			k, ok = s.find(kid)
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if !k.algorithmOK(alg) {
+		return nil, fmt.Errorf("key %q cannot verify %s", kid, alg)
+	}
+	return k.key, nil
+}
+
+func (s *keySet) find(kid string) (signingKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if kid == "" && len(s.keys) == 1 {
+		for _, k := range s.keys {
+			return k, true
+		}
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+// startRefresh reports whether an unknown key ID may trigger a fetch now and
+// records the attempt, so concurrent lookups do not all fetch.
+func (s *keySet) startRefresh() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.fetched) < jwksMinRefresh {
+		return false
+	}
+	s.fetched = time.Now()
+	return true
+}
+
+// refresh fetches the JWKS from the URL. The current keys are kept on error.
+func (s *keySet) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch JWKS: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, jwksMaxBytes))
+	if err != nil {
+		return fmt.Errorf("fetch JWKS: %w", err)
+	}
+	keys, err := parseJWKS(body)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.fetched = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+// Run refreshes a URL based key set every interval until ctx is cancelled.
+func (s *keySet) Run(ctx context.Context, every time.Duration) {
+	if s.url == "" {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fetchCtx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+			if err := s.refresh(fetchCtx); err != nil {
+				logger.Warn("jwks_refresh_failed", "url", s.url, "error", err.Error())
+			}
+			cancel()
+		}
+	}
+}
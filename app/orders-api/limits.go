@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// ---- Rate and size limits ----
+//
+// Every client gets a token bucket of rate_limit.rps with rate_limit.burst
+// tokens: authenticated callers by principal (API key or JWT subject),
+// anonymous ones by client IP. The client IP is the peer address unless the
+// peer is a trusted proxy, in which case X-Forwarded-For is walked from the
+// right past further trusted proxies. Requests over the limit get 429 with
+// Retry-After; bodies over http.max_body_bytes (batch.max_body_bytes for
+// POST /orders:batch) get 413. The probes and /metrics are exempt.
+//
+// Requests that fail authentication never reach that limiter, so withAuth
+// keeps a second bucket per client IP (rate_limit.auth_failure_rps and
+// auth_failure_burst) that only failed attempts drain. While it is empty the
+// IP gets 429 before its credentials are even looked at, which throttles
+// credential guessing and JWTs with made-up key IDs that would refetch the
+// JWKS.
+
+const rateLimitSweepInterval = time.Minute
+
+type rateClient struct {
+	limiter *rate.Limiter
+	seen    time.Time
+}
+
+// RateLimiter holds one token bucket per client key.
+type RateLimiter struct {
+	limit rate.Limit
+	burst int
+	// idle is how long a bucket takes to refill; idler ones are dropped.
+	idle time.Duration
+
+	// gauge tracks the number of buckets.
+	gauge prometheus.Gauge
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+}
+
+// newRateLimiter returns nil when rate limiting is off.
+func newRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return newBuckets(cfg.RPS, cfg.Burst, rateLimitClients)
+}
+
+// newAuthFailureLimiter returns the per-IP limiter of failed authentication
+// attempts, or nil when it is off.
+func newAuthFailureLimiter(cfg RateLimitConfig) *RateLimiter {
+	return newBuckets(cfg.AuthFailureRPS, cfg.AuthFailureBurst, authFailureClients)
+}
+
+func newBuckets(rps float64, burst int, gauge prometheus.Gauge) *RateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		limit:   rate.Limit(rps),
+		burst:   burst,
+		idle:    time.Duration(float64(burst) / rps * float64(time.Second)),
+		gauge:   gauge,
+		clients: map[string]*rateClient{},
+	}
+}
+
+// bucket returns key's bucket, creating it if needed.
+func (l *RateLimiter) bucket(key string, now time.Time) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.seen = now
+	return c.limiter
+}
+
+// empty reports whether key's bucket has no token left, and how long until
+// it has one, without taking any.
+func (l *RateLimiter) empty(key string) (time.Duration, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	c, ok := l.clients[key]
+	l.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	tokens := c.limiter.TokensAt(now)
+	if tokens >= 1 {
+		return 0, false
+	}
+	return time.Duration((1 - tokens) / float64(l.limit) * float64(time.Second)), true
+}
+
+// allow takes a token from key's bucket, or reports how long until one is
+// available.
+func (l *RateLimiter) allow(key string) (time.Duration, bool) {
+	now := time.Now()
+	res := l.bucket(key, now).ReserveN(now, 1)
+	if delay := res.DelayFrom(now); delay > 0 {
+		res.CancelAt(now)
+		return delay, false
+	}
+	return 0, true
+}
+
+// Run drops buckets of clients that have been idle long enough for their
+// bucket to be full again, until ctx is cancelled.
+func (l *RateLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(rateLimitSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for key, c := range l.clients {
+				if now.Sub(c.seen) > l.idle {
+					delete(l.clients, key)
+				}
+			}
+			l.gauge.Set(float64(len(l.clients)))
+			l.mu.Unlock()
+		}
+	}
+}
+
+// parseTrustedProxies parses HTTPConfig.TrustedProxies.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q", field)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", field)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func trusted(addr netip.Addr, proxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range proxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the client that sent r.
+func clientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !trusted(peer, proxies) {
+		return host
+	}
+
+	// Each proxy appends the address it received the request from, so the
+	// right-most entry that is not one of ours is the client.
+	client := peer
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		client = addr
+		if !trusted(addr, proxies) {
+			break
+		}
+	}
+	return client.Unmap().String()
+}
+
+// withLimits applies the body size limit maxBody returns for the request
+// and the per-client rate limit (when limiter is not nil). It runs after
+// withAuth so callers are keyed by principal.
+func withLimits(limiter *RateLimiter, maxBody func(*http.Request) int64, proxies []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if limiter != nil {
+			keyType, key := "principal", ""
+			if p := principalFrom(r.Context()); p != nil && p.ID != anonymousID {
+				key = p.ID
+			} else {
+				keyType, key = "ip", "ip:"+clientIP(r, proxies)
+			}
+			if wait, ok := limiter.allow(key); !ok {
+				requestsThrottledTotal.WithLabelValues(keyType).Inc()
+				logger.DebugContext(r.Context(), "request_throttled",
+					"key", key,
+					"method", r.Method,
+					"path", r.URL.Path,
+				)
+				w.Header().Set("Retry-After", retryAfter(wait))
+				writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+		}
+
+		limit := maxBody(r)
+		if r.ContentLength > limit {
+			requestsOversizedTotal.Inc()
+			writeJSONError(w, http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("request body exceeds %d bytes", limit))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// retryAfter formats d as a Retry-After value in whole seconds, at least 1.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(max(1, int(math.Ceil(d.Seconds()))))
+}
+
+// bodyTooLarge answers 413 if err came from reading past the body limit of
+// a request without Content-Length.
+func bodyTooLarge(w http.ResponseWriter, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	requestsOversizedTotal.Inc()
+	writeJSONError(w, http.StatusRequestEntityTooLarge,
+		fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+	return true
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{in: "", want: nil},
+		{in: "10.0.0.0/8, 192.168.1.7", want: []string{"10.0.0.0/8", "192.168.1.7/32"}},
+		{in: "10.1.2.3/8", want: []string{"10.0.0.0/8"}},
+		{in: "::ffff:10.0.0.1,fd00::/8", want: []string{"10.0.0.1/32", "fd00::/8"}},
+		{in: ",,", want: nil},
+		{in: "proxy.local", wantErr: `invalid address "proxy.local"`},
+		{in: "10.0.0.0/33", wantErr: `invalid CIDR "10.0.0.0/33"`},
+	}
+	for _, tt := range tests {
+		got, err := parseTrustedProxies(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseTrustedProxies(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTrustedProxies(%q): %v", tt.in, err)
+			continue
+		}
+		var gotStrings []string
+		for _, p := range got {
+			gotStrings = append(gotStrings, p.String())
+		}
+		if !reflect.DeepEqual(gotStrings, tt.want) {
+			t.Errorf("parseTrustedProxies(%q) = %q, want %q", tt.in, gotStrings, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8,fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		xff     []string
+		proxies []netip.Prefix
+		want    string
+	}{
+		{name: "direct", remote: "203.0.113.5:4711", proxies: proxies, want: "203.0.113.5"},
+		{
+			name:    "spoofed header from untrusted peer",
+			remote:  "203.0.113.5:4711",
+			xff:     []string{"198.51.100.1"},
+			proxies: proxies,
+			want:    "203.0.113.5",
+		},
+		{
+			name:   "no trusted proxies",
+			remote: "10.0.0.2:4711",
+			xff:    []string{"198.51.100.1"},
+			want:   "10.0.0.2",
+		},
+		{
+			name:    "through one proxy",
+			remote:  "10.0.0.2:4711",
+			xff:     []string{"198.51.100.1"},
+			proxies: proxies,
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "client prepends a spoofed address",
+			remote:  "10.0.0.2:4711",
+			xff:     []string{"1.2.3.4, 198.51.100.1"},
+			proxies: proxies,
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "chain of trusted proxies",
+			remote:  "10.0.0.2:4711",
+			xff:     []string{"1.2.3.4, 198.51.100.1, 10.9.9.9", "10.0.0.3"},
+			proxies: proxies,
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "only proxies",
+			remote:  "10.0.0.2:4711",
+			xff:     []string{"10.0.0.4"},
+			proxies: proxies,
+			want:    "10.0.0.4",
+		},
+		{
+			name:    "garbage stops the walk",
+			remote:  "10.0.0.2:4711",
+			xff:     []string{"1.2.3.4, unknown, 10.0.0.9"},
+			proxies: proxies,
+			want:    "10.0.0.9",
+		},
+		{
+			name:    "no header",
+			remote:  "10.0.0.2:4711",
+			proxies: proxies,
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "IPv6 proxy",
+			remote:  "[fd00::1]:4711",
+			xff:     []string{"2001:db8::7"},
+			proxies: proxies,
+			want:    "2001:db8::7",
+		},
+		{
+			name:    "IPv4-mapped client",
+			remote:  "10.0.0.2:4711",
+			xff:     []string{"::ffff:198.51.100.1"},
+			proxies: proxies,
+			want:    "198.51.100.1",
+		},
+		{name: "no port", remote: "203.0.113.5", proxies: proxies, want: "203.0.113.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r, tt.proxies); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "1"},
+		{10 * time.Millisecond, "1"},
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Minute, "60"},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.d); got != tt.want {
+			t.Errorf("retryAfter(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestWithAuthThrottlesFailures(t *testing.T) {
+	keys, err := parseAPIKeys("web " + keyHash("web-secret") + " orders:read")
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := newAuthFailureLimiter(RateLimitConfig{AuthFailureRPS: 0.001, AuthFailureBurst: 2})
+	h := withAuth(keys, failures, nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	steps := []struct {
+		remote string
+		key    string
+		want   int
+	}{
+		{"203.0.113.5:1", "guess-1", http.StatusUnauthorized},
+		{"203.0.113.5:2", "web-secret", http.StatusOK}, // successes take no token
+		{"203.0.113.5:3", "guess-2", http.StatusUnauthorized},
+		{"203.0.113.5:4", "guess-3", http.StatusTooManyRequests},
+		{"203.0.113.5:5", "web-secret", http.StatusTooManyRequests}, // credentials are not even checked
+		{"198.51.100.1:1", "guess-1", http.StatusUnauthorized},      // other IPs are unaffected
+		{"198.51.100.1:2", "web-secret", http.StatusOK},
+	}
+	for i, s := range steps {
+		r := httptest.NewRequest(http.MethodGet, "/orders", nil)
+		r.RemoteAddr = s.remote
+		r.Header.Set("X-API-Key", s.key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != s.want {
+			t.Fatalf("step %d (%s from %s): status = %d, want %d", i, s.key, s.remote, w.Code, s.want)
+		}
+		if w.Code == http.StatusTooManyRequests {
+			if ra := w.Header().Get("Retry-After"); ra == "" || strings.HasPrefix(ra, "0") {
+				t.Errorf("step %d: Retry-After = %q", i, ra)
+			}
+		}
+	}
+}
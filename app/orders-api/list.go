@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/praivan/orders-core/order"
+)
+
+// ---- Order listing ----
+//
+// GET /orders and the HTML index page page through orders with a keyset
+// cursor over (created_at, order_id), so deep pages cost the same as the
+// first one. The cursor is opaque to clients.
+
+// ListQuery is the parsed query string of GET /orders.
+type ListQuery struct {
+	Limit         int
+	Ascending     bool // sort=created_at; the default is -created_at (newest first)
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	OrderIDPrefix string
+	Statuses      []order.Status
+	Cursor        *listCursor
+}
+
+// ShowsNewest reports whether the page starts at the newest order, i.e. new
+// orders from the live feed belong at its top.
+func (q ListQuery) ShowsNewest() bool {
+	return q.Cursor == nil && !q.Ascending && q.CreatedBefore.IsZero() &&
+		q.OrderIDPrefix == "" && len(q.Statuses) == 0
+}
+
+// OrderPage is the body of GET /orders.
+type OrderPage struct {
+	Orders     []OrderRow `json:"orders"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+	PrevCursor string     `json:"prev_cursor,omitempty"`
+}
+
+// listCursor is the position to continue from. Before pages backwards
+// (towards the previous page) instead of forwards.
+type listCursor struct {
+	CreatedAt time.Time `json:"t"`
+	OrderID   string    `json:"id"`
+	Before    bool      `json:"b,omitempty"`
+	Ascending bool      `json:"a,omitempty"`
+}
+
+func (c listCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.CreatedAt.IsZero() {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parseListQuery validates the query string of GET /orders (and /).
+func parseListQuery(v url.Values, lc ListConfig) (ListQuery, error) {
+	q := ListQuery{Limit: lc.DefaultLimit}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > lc.MaxLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", lc.MaxLimit)
+		}
+		q.Limit = n
+	}
+
+	switch v.Get("sort") {
+	case "", "-created_at":
+	case "created_at":
+		q.Ascending = true
+	default:
+		return q, errors.New("sort must be created_at or -created_at")
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_after", &q.CreatedAfter},
+		{"created_before", &q.CreatedBefore},
+	} {
+		s := v.Get(p.name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return q, fmt.Errorf("%s must be an RFC 3339 timestamp", p.name)
+		}
+		*p.dst = t
+	}
+
+	q.OrderIDPrefix = v.Get("order_id_prefix")
+	if len(q.OrderIDPrefix) > order.MaxOrderIDLen {
+		return q, fmt.Errorf("order_id_prefix must be at most %d characters", order.MaxOrderIDLen)
+	}
+
+	if s := v.Get("status"); s != "" {
+		for _, part := range strings.Split(s, ",") {
+			st := order.Status(strings.TrimSpace(part))
+			if !st.Valid() {
+				return q, fmt.Errorf("unknown status %q", part)
+			}
+			q.Statuses = append(q.Statuses, st)
+		}
+	}
+
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeCursor(s)
+		if err != nil {
+			return q, err
+		}
+		if c.Ascending != q.Ascending {
+			return q, errors.New("cursor does not match sort")
+		}
+		q.Cursor = c
+	}
+
+	return q, nil
+}
+
+// likePrefix escapes LIKE wildcards in s and appends %.
+func likePrefix(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s) + "%"
+}
+
+func listOrders(ctx context.Context, q ListQuery) (OrderPage, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if !q.CreatedAfter.IsZero() {
+		where = append(where, "created_at >= "+arg(q.CreatedAfter))
+	}
+	if !q.CreatedBefore.IsZero() {
+		where = append(where, "created_at < "+arg(q.CreatedBefore))
+	}
+	if q.OrderIDPrefix != "" {
+		where = append(where, "order_id LIKE "+arg(likePrefix(q.OrderIDPrefix)))
+	}
+	if len(q.Statuses) > 0 {
+		statuses := make([]string, len(q.Statuses))
+		for i, s := range q.Statuses {
+			statuses[i] = string(s)
+		}
+		where = append(where, "status = ANY("+arg(pq.Array(statuses))+")")
+	}
+
+	// Paging backwards scans in the opposite direction and reverses the rows.
+	backward := q.Cursor != nil && q.Cursor.Before
+	scanDesc := !q.Ascending != backward
+	if q.Cursor != nil {
+		op := ">"
+		if scanDesc {
+			op = "<"
+		}
+		where = append(where, fmt.Sprintf("(created_at, order_id) %s (%s, %s)",
+			op, arg(q.Cursor.CreatedAt), arg(q.Cursor.OrderID)))
+	}
+
+	dir := "ASC"
+	if scanDesc {
+		dir = "DESC"
+	}
+	query := `
+		SELECT order_id, status, quantity, COALESCE(currency, ''), total_cents, created_at
+		FROM orders`
+	if len(where) > 0 {
+		query += "\n\t\tWHERE " + strings.Join(where, " AND ")
+	}
+	// One extra row tells us whether there is another page.
+	query += fmt.Sprintf("\n\t\tORDER BY created_at %s, order_id %s\n\t\tLIMIT %s", dir, dir, arg(q.Limit+1))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return OrderPage{}, err
+	}
+	defer rows.Close()
+
+	out := make([]OrderRow, 0, q.Limit+1)
+	for rows.Next() {
+		var o OrderRow
+		if err := rows.Scan(&o.OrderID, &o.Status, &o.Quantity, &o.Currency, &o.TotalCents, &o.CreatedAt); err != nil {
+			return OrderPage{}, err
+		}
+		out = append(out, o)
+	}
+	if err := rows.Err(); err != nil {
+		return OrderPage{}, err
+	}
+
+	more := len(out) > q.Limit
+	if more {
+		out = out[:q.Limit]
+	}
+	if backward {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+
+	if err := loadItems(ctx, out); err != nil {
+		return OrderPage{}, err
+	}
+
+	page := OrderPage{Orders: out}
+	if len(out) == 0 {
+		return page, nil
+	}
+	first, last := out[0], out[len(out)-1]
+	// Forwards there is a next page if we saw an extra row and a previous one
+	// if we came from a cursor; backwards it is the other way round.
+	if more || backward {
+		page.NextCursor = listCursor{CreatedAt: last.CreatedAt, OrderID: last.OrderID, Ascending: q.Ascending}.encode()
+	}
+	if (backward && more) || (!backward && q.Cursor != nil) {
+		page.PrevCursor = listCursor{CreatedAt: first.CreatedAt, OrderID: first.OrderID, Before: true, Ascending: q.Ascending}.encode()
+	}
+	return page, nil
+}
+
+// pageURL is path with the current query string and cursor replaced.
+func pageURL(path string, v url.Values, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	next := url.Values{}
+	for k, vs := range v {
+		next[k] = vs
+	}
+	next.Set("cursor", cursor)
+	return path + "?" + next.Encode()
+}
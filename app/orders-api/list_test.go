@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/praivan/orders-core/order"
+)
+
+func TestParseListQuery(t *testing.T) {
+	lc := ListConfig{DefaultLimit: 50, MaxLimit: 500}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	desc := listCursor{CreatedAt: at, OrderID: "o-9"}.encode()
+	asc := listCursor{CreatedAt: at, OrderID: "o-9", Ascending: true}.encode()
+
+	tests := []struct {
+		name    string
+		query   string
+		want    ListQuery
+		wantErr string
+	}{
+		{name: "defaults", query: "", want: ListQuery{Limit: 50}},
+		{name: "limit", query: "limit=500", want: ListQuery{Limit: 500}},
+		{name: "limit zero", query: "limit=0", wantErr: "limit must be between 1 and 500"},
+		{name: "limit too big", query: "limit=501", wantErr: "limit must be between 1 and 500"},
+		{name: "limit not a number", query: "limit=ten", wantErr: "limit must be between"},
+		{name: "newest first", query: "sort=-created_at", want: ListQuery{Limit: 50}},
+		{name: "oldest first", query: "sort=created_at", want: ListQuery{Limit: 50, Ascending: true}},
+		{name: "unknown sort", query: "sort=order_id", wantErr: "sort must be"},
+		{
+			name:  "time range",
+			query: "created_after=2024-05-01T12:00:00Z&created_before=2024-05-02T00:00:00%2B02:00",
+			want: ListQuery{
+				Limit:         50,
+				CreatedAfter:  at,
+				CreatedBefore: time.Date(2024, 5, 2, 0, 0, 0, 0, time.FixedZone("", 2*60*60)),
+			},
+		},
+		{name: "bad time", query: "created_before=yesterday", wantErr: "created_before must be an RFC 3339 timestamp"},
+		{name: "prefix", query: "order_id_prefix=web-", want: ListQuery{Limit: 50, OrderIDPrefix: "web-"}},
+		{
+			name:    "long prefix",
+			query:   "order_id_prefix=" + strings.Repeat("x", order.MaxOrderIDLen+1),
+			wantErr: "order_id_prefix must be at most",
+		},
+		{
+			name:  "statuses",
+			query: "status=failed,+cancelled",
+			want:  ListQuery{Limit: 50, Statuses: []order.Status{order.StatusFailed, order.StatusCancelled}},
+		},
+		{name: "unknown status", query: "status=failed,shipped", wantErr: `unknown status "shipped"`},
+		{
+			name:  "cursor",
+			query: "cursor=" + desc,
+			want:  ListQuery{Limit: 50, Cursor: &listCursor{CreatedAt: at, OrderID: "o-9"}},
+		},
+		{
+			name:  "ascending cursor",
+			query: "sort=created_at&cursor=" + asc,
+			want:  ListQuery{Limit: 50, Ascending: true, Cursor: &listCursor{CreatedAt: at, OrderID: "o-9", Ascending: true}},
+		},
+		{name: "cursor from other sort", query: "cursor=" + asc, wantErr: "cursor does not match sort"},
+		{name: "garbage cursor", query: "cursor=%21%21", wantErr: "invalid cursor"},
+		{name: "cursor without time", query: "cursor=" + listCursor{OrderID: "o-9"}.encode(), wantErr: "invalid cursor"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseListQuery(v, lc)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseListQuery(%q) error = %v, want %q", tt.query, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseListQuery(%q): %v", tt.query, err)
+			}
+			if !got.CreatedBefore.Equal(tt.want.CreatedBefore) {
+				t.Errorf("CreatedBefore = %v, want %v", got.CreatedBefore, tt.want.CreatedBefore)
+			}
+			got.CreatedBefore, tt.want.CreatedBefore = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseListQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	for _, c := range []listCursor{
+		{CreatedAt: at, OrderID: "o-1"},
+		{CreatedAt: at, OrderID: "o/2 ü", Before: true},
+		{CreatedAt: at, OrderID: "o-3", Ascending: true},
+		{CreatedAt: at, OrderID: "o-4", Before: true, Ascending: true},
+	} {
+		s := c.encode()
+		if strings.ContainsAny(s, "+/=") {
+			t.Errorf("cursor %q is not URL-safe", s)
+		}
+		got, err := decodeCursor(s)
+		if err != nil {
+			t.Fatalf("decodeCursor(%q): %v", s, err)
+		}
+		if !got.CreatedAt.Equal(c.CreatedAt) || got.OrderID != c.OrderID ||
+			got.Before != c.Before || got.Ascending != c.Ascending {
+			t.Errorf("round trip of %+v = %+v", c, *got)
+		}
+	}
+}
+
+func TestPageURL(t *testing.T) {
+	v := url.Values{"limit": {"10"}, "cursor": {"old"}}
+	if got := pageURL("/orders", v, ""); got != "" {
+		t.Errorf("pageURL without cursor = %q, want empty", got)
+	}
+	if got, want := pageURL("/orders", v, "new"), "/orders?cursor=new&limit=10"; got != want {
+		t.Errorf("pageURL = %q, want %q", got, want)
+	}
+	if v.Get("cursor") != "old" {
+		t.Errorf("pageURL modified its input: %v", v)
+	}
+}
@@ -7,26 +7,30 @@ import (
 	"errors"
 	"fmt"
 	"html/template"
-	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/praivan/orders-core/config"
+	"github.com/praivan/orders-core/logging"
+	"github.com/praivan/orders-core/migrate"
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/store"
+	"github.com/praivan/orders-core/telemetry"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type OrderRequest struct {
-	OrderID string `json:"order_id"`
-}
-
-type OrderRow struct {
-	OrderID   string
-	CreatedAt time.Time
-}
-
 // ---- Metrics ----
 
 var (
@@ -38,6 +42,47 @@ var (
 		[]string{"handler", "method", "code"},
 	)
 
+	httpRequestsByPrincipal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_http_requests_by_principal_total",
+			Help: "Total authenticated HTTP requests by API key or JWT client",
+		},
+		[]string{"principal", "handler", "code"},
+	)
+	authFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_auth_failures_total",
+			Help: "Total requests rejected by authentication or authorization",
+		},
+		[]string{"reason"}, // missing | invalid | forbidden
+	)
+
+	requestsThrottledTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_http_throttled_total",
+			Help: "Total requests rejected with 429 by the per-client rate limit",
+		},
+		[]string{"key"}, // principal | ip | auth_failure
+	)
+	requestsOversizedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "orders_http_oversized_requests_total",
+			Help: "Total requests rejected with 413 for exceeding the body size limit",
+		},
+	)
+	rateLimitClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_rate_limit_clients",
+			Help: "Number of clients with a rate limit bucket",
+		},
+	)
+	authFailureClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_auth_failure_limit_clients",
+			Help: "Number of client IPs with a failed authentication bucket",
+		},
+	)
+
 	httpRequestDuration = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "orders_http_request_duration_seconds",
@@ -53,186 +98,327 @@ var (
 			Help: "Total number of orders published to RabbitMQ",
 		},
 	)
-	ordersPublishFailuresTotal = prometheus.NewCounter(
+	ordersPublishFailuresTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "orders_publish_failures_total",
 			Help: "Total number of failures publishing orders to RabbitMQ",
 		},
+		[]string{"reason"}, // unavailable | nack | confirm_timeout | error
+	)
+
+	rabbitmqConnected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_rabbitmq_connected",
+			Help: "Whether orders-api currently holds a healthy RabbitMQ channel (1) or not (0)",
+		},
+	)
+	rabbitmqReconnectsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_rabbitmq_reconnects_total",
+			Help: "Total RabbitMQ reconnect attempts by orders-api",
+		},
+		[]string{"result"}, // success | failure
+	)
+
+	queueDepth = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_queue_depth",
+			Help: "Ready messages in the orders queue at the last inspection",
+		},
+	)
+	queueConsumers = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_queue_consumers",
+			Help: "Consumers of the orders queue at the last inspection",
+		},
+	)
+	rabbitmqBlocked = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_rabbitmq_blocked",
+			Help: "Whether RabbitMQ has blocked orders-api's connection (1) or not (0)",
+		},
+	)
+	backpressureRejectionsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_backpressure_rejections_total",
+			Help: "Total POST /orders requests rejected with 503 because the queue is saturated",
+		},
+		[]string{"reason"}, // blocked | no_consumers | queue_depth
+	)
+
+	outboxPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_outbox_pending",
+			Help: "Number of orders in the outbox not yet published to RabbitMQ",
+		},
+	)
+	outboxOldestPendingAge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_outbox_oldest_pending_age_seconds",
+			Help: "Age of the oldest unpublished order in the outbox",
+		},
+	)
+
+	ordersBatchRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_batch_records_total",
+			Help: "Total POST /orders:batch records by result (accepted, invalid, duplicate)",
+		},
+		[]string{"result"},
+	)
+	ordersIdempotentReplaysTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "orders_idempotent_replays_total",
+			Help: "Total POST /orders requests answered from a stored idempotent response",
+		},
+	)
+
+	streamClients = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_stream_clients",
+			Help: "Number of connected /orders/stream clients",
+		},
+	)
+	streamClientsDroppedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "orders_stream_clients_dropped_total",
+			Help: "Total /orders/stream clients disconnected for falling behind",
+		},
 	)
 )
 
 func init() {
 	prometheus.MustRegister(
 		httpRequestsTotal,
+		httpRequestsByPrincipal,
+		authFailuresTotal,
+		requestsThrottledTotal,
+		requestsOversizedTotal,
+		rateLimitClients,
+		authFailureClients,
 		httpRequestDuration,
 		ordersPublishedTotal,
 		ordersPublishFailuresTotal,
+		rabbitmqConnected,
+		rabbitmqReconnectsTotal,
+		queueDepth,
+		queueConsumers,
+		rabbitmqBlocked,
+		backpressureRejectionsTotal,
+		outboxPending,
+		outboxOldestPendingAge,
+		ordersBatchRecordsTotal,
+		ordersIdempotentReplaysTotal,
+		streamClients,
+		streamClientsDroppedTotal,
 	)
 }
 
-// ---- Logging helpers ----
+// ---- Logging and tracing ----
 
-func logInfo(event string, fields map[string]interface{}) {
-	if fields == nil {
-		fields = make(map[string]interface{})
-	}
-	fields["event"] = event
-	fields["level"] = "info"
-	b, _ := json.Marshal(fields)
-	log.Println(string(b))
-}
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
 
-func logError(event string, fields map[string]interface{}) {
-	if fields == nil {
-		fields = make(map[string]interface{})
-	}
-	fields["event"] = event
-	fields["level"] = "error"
-	b, _ := json.Marshal(fields)
-	log.Println(string(b))
-}
+var (
+	logger = logging.New("orders-api", version)
+	tracer = telemetry.Tracer("orders-api")
+)
 
-// ---- RabbitMQ publisher ----
+// ---- Postgres ----
 
-type Publisher struct {
-	ch   *amqp.Channel
-	conn *amqp.Connection
-	q    amqp.Queue
-}
+var db *sql.DB
 
-func newPublisher(amqpURL, queueName string) (*Publisher, error) {
-	conn, err := amqp.Dial(amqpURL)
+func initDB(dsn string) (*sql.DB, error) {
+	db, err := openDB(dsn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+		return nil, err
 	}
 
-	ch, err := conn.Channel()
-	if err != nil {
-		_ = conn.Close()
-		return nil, fmt.Errorf("failed to open channel: %w", err)
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	q, err := ch.QueueDeclare(
-		queueName,
-		true,  // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,   // args
-	)
-	if err != nil {
-		_ = ch.Close()
-		_ = conn.Close()
-		return nil, fmt.Errorf("failed to declare queue: %w", err)
+	// The schema is owned by `orders-api migrate up` (run as a Kubernetes Job);
+	// refuse to start against a database that has not been migrated yet.
+	if err := migrate.Check(ctx, db); err != nil {
+		_ = db.Close()
+		return nil, err
 	}
 
-	logInfo("rabbitmq_connected", map[string]interface{}{
-		"queue": queueName,
-	})
-
-	return &Publisher{
-		ch:   ch,
-		conn: conn,
-		q:    q,
-	}, nil
+	logger.Info("postgres_connected", "dsn", "redacted")
+	return db, nil
 }
 
-func (p *Publisher) PublishOrder(order OrderRequest) error {
-	body, err := json.Marshal(order)
+func openDB(dsn string) (*sql.DB, error) {
+	db, err := telemetry.OpenDB(dsn)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("sql.Open: %w", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err = p.ch.PublishWithContext(
-		ctx,
-		"",       // default exchange
-		p.q.Name, // routing key
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        body,
-		},
-	)
-	if err != nil {
-		return err
+	if err := db.PingContext(ctx); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("ping db: %w", err)
 	}
-
-	return nil
+	return db, nil
 }
 
-func (p *Publisher) Close() {
-	if p.ch != nil {
-		_ = p.ch.Close()
+// runMigrate implements `orders-api migrate ...`; it only needs the Postgres
+// section of the configuration.
+func runMigrate(args []string) {
+	cfg := defaultConfig()
+	var problems config.Problems
+	err := config.Load(&cfg)
+	if err == nil {
+		cfg.Postgres.Validate(&problems)
+		err = problems.Err()
 	}
-	if p.conn != nil {
-		_ = p.conn.Close()
+	if err != nil {
+		logger.Fatal("config_invalid", "error", err.Error())
 	}
-}
 
-// ---- Postgres ----
+	db, err := openDB(string(cfg.Postgres.DSN))
+	if err != nil {
+		logger.Error("postgres_connect_failed", "error", err.Error())
+		os.Exit(1)
+	}
+	defer db.Close()
 
-var db *sql.DB
+	if err := migrate.Command(context.Background(), db, args, os.Stdout); err != nil {
+		logger.Error("migrate_failed", "args", args, "error", err.Error())
+		db.Close()
+		os.Exit(1)
+	}
+}
 
-func initDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, fmt.Errorf("sql.Open: %w", err)
+// OrderRow is an order as listed by GET /orders (see list.go).
+type OrderRow struct {
+	OrderID    string       `json:"order_id"`
+	Status     order.Status `json:"status"`
+	Quantity   int          `json:"quantity"`
+	Currency   string       `json:"currency,omitempty"`
+	TotalCents int64        `json:"total_cents"`
+	Items      []order.Item `json:"items,omitempty"`
+	CreatedAt  time.Time    `json:"created_at"`
+}
+
+// loadItems attaches line items to orders with a single query.
+func loadItems(ctx context.Context, orders []OrderRow) error {
+	if len(orders) == 0 {
+		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	index := make(map[string]int, len(orders))
+	ids := make([]string, len(orders))
+	for i, o := range orders {
+		index[o.OrderID] = i
+		ids[i] = o.OrderID
+	}
 
-	if err := db.PingContext(ctx); err != nil {
-		return nil, fmt.Errorf("ping db: %w", err)
+	rows, err := db.QueryContext(ctx, `
+		SELECT order_id, sku, quantity, unit_price_cents
+		FROM order_items
+		WHERE order_id = ANY($1)
+		ORDER BY order_id, line_no
+	`, pq.Array(ids))
+	if err != nil {
+		return err
 	}
+	defer rows.Close()
 
-	// Simple schema for demo
-	_, err = db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS orders (
-			order_id   TEXT PRIMARY KEY,
-			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	for rows.Next() {
+		var (
+			orderID string
+			it      order.Item
 		)
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("create table: %w", err)
+		if err := rows.Scan(&orderID, &it.SKU, &it.Quantity, &it.UnitPriceCents); err != nil {
+			return err
+		}
+		i := index[orderID]
+		orders[i].Items = append(orders[i].Items, it)
 	}
+	return rows.Err()
+}
 
-	logInfo("postgres_connected", map[string]interface{}{
-		"dsn": "redacted",
-	})
-	return db, nil
+// OrderDetail is the body of GET /orders/{id}.
+type OrderDetail struct {
+	OrderRow
+	RequestID     string             `json:"request_id,omitempty"`
+	Principal     string             `json:"principal,omitempty"`
+	FailureReason string             `json:"failure_reason,omitempty"`
+	UpdatedAt     time.Time          `json:"updated_at"`
+	History       []StatusTransition `json:"history"`
 }
 
-func listOrders(ctx context.Context) ([]OrderRow, error) {
-	rows, err := db.QueryContext(ctx, `
-		SELECT order_id, created_at
+// StatusTransition is one entry of an order's status history.
+type StatusTransition struct {
+	From      order.Status `json:"from,omitempty"`
+	To        order.Status `json:"to"`
+	Reason    string       `json:"reason,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Principal string       `json:"principal,omitempty"`
+	At        time.Time    `json:"at"`
+}
+
+// orderURL is the Location of an accepted order.
+func orderURL(orderID string) string {
+	return "/orders/" + url.PathEscape(orderID)
+}
+
+func getOrder(ctx context.Context, orderID string) (OrderDetail, error) {
+	var o OrderDetail
+	err := db.QueryRowContext(ctx, `
+		SELECT order_id, status, quantity, COALESCE(currency, ''), total_cents,
+		       COALESCE(request_id, ''), COALESCE(principal, ''), COALESCE(failure_reason, ''),
+		       created_at, updated_at
 		FROM orders
-		ORDER BY created_at DESC
-		LIMIT 50
-	`)
+		WHERE order_id = $1
+	`, orderID).Scan(
+		&o.OrderID, &o.Status, &o.Quantity, &o.Currency, &o.TotalCents,
+		&o.RequestID, &o.Principal, &o.FailureReason, &o.CreatedAt, &o.UpdatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return OrderDetail{}, store.ErrNotFound
+	}
 	if err != nil {
-		return nil, err
+		return OrderDetail{}, err
 	}
-	defer rows.Close()
 
-	var out []OrderRow
-	for rows.Next() {
-		var o OrderRow
-		if err := rows.Scan(&o.OrderID, &o.CreatedAt); err != nil {
-			return nil, err
+	rows := []OrderRow{o.OrderRow}
+	if err := loadItems(ctx, rows); err != nil {
+		return OrderDetail{}, err
+	}
+	o.Items = rows[0].Items
+
+	hist, err := db.QueryContext(ctx, `
+		SELECT COALESCE(from_status, ''), to_status, COALESCE(reason, ''),
+		       COALESCE(request_id, ''), COALESCE(principal, ''), created_at
+		FROM order_status_history
+		WHERE order_id = $1
+		ORDER BY id
+	`, orderID)
+	if err != nil {
+		return OrderDetail{}, err
+	}
+	defer hist.Close()
+
+	o.History = []StatusTransition{}
+	for hist.Next() {
+		var t StatusTransition
+		if err := hist.Scan(&t.From, &t.To, &t.Reason, &t.RequestID, &t.Principal, &t.At); err != nil {
+			return OrderDetail{}, err
 		}
-		out = append(out, o)
+		o.History = append(o.History, t)
 	}
-	return out, rows.Err()
+	return o, hist.Err()
 }
 
 // ---- HTML template ----
 
-var indexTpl = template.Must(template.New("index").Parse(`
+var indexTpl = template.Must(template.New("index").Funcs(template.FuncMap{
+	"money": order.FormatMoney,
+}).Parse(`
 <!doctype html>
 <html lang="en">
   <head>
@@ -382,6 +568,22 @@ var indexTpl = template.Must(template.New("index").Parse(`
         transition: border-color 0.15s ease, box-shadow 0.15s ease, background 0.15s ease;
       }
 
+      #quantity {
+        width: 90px;
+        padding: 0.75rem 0.75rem;
+        border-radius: var(--radius-md);
+        border: 1px solid rgba(255, 255, 255, 0.12);
+        background: rgba(3, 2, 15, 0.9);
+        color: var(--text-main);
+        font-size: 0.95rem;
+        outline: none;
+      }
+
+      #quantity:focus {
+        border-color: var(--accent);
+        box-shadow: 0 0 0 1px rgba(123, 0, 255, 0.6);
+      }
+
       #order_id::placeholder {
         color: rgba(163, 163, 194, 0.85);
       }
@@ -519,6 +721,49 @@ var indexTpl = template.Must(template.New("index").Parse(`
         color: var(--text-muted);
       }
 
+      tbody td:first-child a {
+        color: inherit;
+        text-decoration: none;
+      }
+
+      .status {
+        display: inline-block;
+        padding: 0.1rem 0.55rem;
+        border-radius: 999px;
+        font-size: 0.75rem;
+        letter-spacing: 0.06em;
+        text-transform: uppercase;
+        background: rgba(255, 255, 255, 0.08);
+        color: var(--text-muted);
+      }
+
+      .status-completed {
+        background: rgba(46, 204, 113, 0.18);
+        color: #7dffb0;
+      }
+
+      .status-failed {
+        background: rgba(255, 71, 87, 0.2);
+        color: #ff9aa5;
+      }
+
+      .status-processing {
+        background: var(--accent-soft);
+        color: #f3e7ff;
+      }
+
+      .orders-pager {
+        display: flex;
+        justify-content: space-between;
+        margin-top: 0.9rem;
+        font-size: 0.85rem;
+      }
+
+      .orders-pager a {
+        color: #f3e7ff;
+        text-decoration: none;
+      }
+
       .empty-state {
         text-align: center;
         padding: 1rem 0.75rem;
@@ -533,7 +778,7 @@ var indexTpl = template.Must(template.New("index").Parse(`
         <header class="page-header">
           <div class="page-kicker">Orders</div>
           <h1>Orders Demo</h1>
-          <p class="header-sub">Create a new order and see it appear in the latest 50 orders from Postgres.</p>
+          <p class="header-sub">Create a new order and see it appear in the latest {{.Limit}} orders from Postgres.</p>
         </header>
 
         <section class="card">
@@ -548,6 +793,14 @@ var indexTpl = template.Must(template.New("index").Parse(`
                 required
                 placeholder="Enter an order identifier"
               >
+              <input
+                name="quantity"
+                id="quantity"
+                type="number"
+                min="1"
+                value="1"
+                aria-label="Quantity"
+              >
               <button type="submit">
                 Create order
               </button>
@@ -558,7 +811,7 @@ var indexTpl = template.Must(template.New("index").Parse(`
         </section>
 
         <section class="orders-section">
-          <h2 class="orders-title">Last 50 Orders</h2>
+          <h2 class="orders-title">Orders</h2>
           <p class="orders-subtitle">Latest entries loaded directly from Postgres.</p>
 
           <div class="orders-table-wrap">
@@ -566,33 +819,46 @@ var indexTpl = template.Must(template.New("index").Parse(`
               <thead>
                 <tr>
                   <th>Order ID</th>
+                  <th>Status</th>
+                  <th>Qty</th>
+                  <th>Items</th>
+                  <th>Total</th>
                   <th>Created at</th>
                 </tr>
               </thead>
-              <tbody id="orders-body">
+              <tbody id="orders-body" data-live="{{.Live}}">
                 {{range .Orders}}
-                <tr>
-                  <td>{{.OrderID}}</td>
+                <tr data-order-id="{{.OrderID}}">
+                  <td><a href="/orders/{{.OrderID}}">{{.OrderID}}</a></td>
+                  <td><span class="status status-{{.Status}}">{{.Status}}</span></td>
+                  <td>{{.Quantity}}</td>
+                  <td>{{range $i, $it := .Items}}{{if $i}}, {{end}}{{$it.Quantity}}× {{$it.SKU}}{{else}}–{{end}}</td>
+                  <td>{{money .TotalCents .Currency}}</td>
                   <td>{{.CreatedAt}}</td>
                 </tr>
                 {{else}}
                 <tr>
-                  <td colspan="2" class="empty-state">No orders yet.</td>
+                  <td colspan="6" class="empty-state">No orders yet.</td>
                 </tr>
                 {{end}}
               </tbody>
             </table>
           </div>
+
+          <nav class="orders-pager">
+            {{if .PrevURL}}<a href="{{.PrevURL}}">&larr; Previous</a>{{else}}<span></span>{{end}}
+            {{if .NextURL}}<a href="{{.NextURL}}">Next &rarr;</a>{{end}}
+          </nav>
         </section>
       </div>
     </div>
 
     <script>
-      async function postOrder(id) {
+      async function postOrder(id, quantity) {
         const res = await fetch('/orders', {
           method: 'POST',
           headers: {'Content-Type': 'application/json'},
-          body: JSON.stringify({order_id: id})
+          body: JSON.stringify({order_id: id, quantity: quantity})
         });
         if (!res.ok) {
           const text = await res.text();
@@ -617,16 +883,59 @@ var indexTpl = template.Must(template.New("index").Parse(`
         e.preventDefault();
         const input = document.getElementById('order_id');
         const id = input.value.trim();
+        const quantity = parseInt(document.getElementById('quantity').value, 10) || 1;
         if (!id) return;
         try {
           setStatus('Sending...');
-          await postOrder(id);
-          setStatus('Order accepted. Refresh in a moment to see it in the list.');
+          await postOrder(id, quantity);
+          setStatus('Order accepted. Watch its status below.');
           input.value = '';
         } catch (err) {
           setStatus(err.toString(), 'error');
         }
       });
+
+      // Live feed: update rows in place and prepend new orders when this page
+      // shows the newest ones. EventSource reconnects with Last-Event-ID itself.
+      const ordersBody = document.getElementById('orders-body');
+      const liveInsert = ordersBody.dataset.live === 'true';
+
+      function formatMoney(cents, currency) {
+        if (!currency) return '';
+        const sign = cents < 0 ? '-' : '';
+        cents = Math.abs(cents);
+        return sign + Math.floor(cents / 100) + '.' + String(cents % 100).padStart(2, '0') + ' ' + currency;
+      }
+
+      function applyEvent(ev) {
+        let tr = ordersBody.querySelector('tr[data-order-id="' + CSS.escape(ev.order_id) + '"]');
+        if (!tr) {
+          if (!liveInsert) return;
+          const empty = ordersBody.querySelector('.empty-state');
+          if (empty) empty.parentElement.remove();
+
+          tr = document.createElement('tr');
+          tr.dataset.orderId = ev.order_id;
+          tr.innerHTML = '<td><a></a></td><td><span class="status"></span></td><td></td><td>–</td><td></td><td></td>';
+          const link = tr.querySelector('a');
+          link.href = '/orders/' + encodeURIComponent(ev.order_id);
+          link.textContent = ev.order_id;
+          tr.cells[5].textContent = ev.created_at;
+          ordersBody.prepend(tr);
+        }
+
+        const badge = tr.querySelector('.status');
+        badge.textContent = ev.status;
+        badge.className = 'status status-' + ev.status;
+        badge.title = ev.failure_reason || '';
+        tr.cells[2].textContent = ev.quantity;
+        tr.cells[4].textContent = formatMoney(ev.total_cents, ev.currency);
+      }
+
+      if (window.EventSource) {
+        const feed = new EventSource('/orders/stream');
+        feed.addEventListener('order', (e) => applyEvent(JSON.parse(e.data)));
+      }
     </script>
   </body>
 </html>
@@ -636,61 +945,129 @@ var indexTpl = template.Must(template.New("index").Parse(`
 // ---- HTTP handlers ----
 
 func main() {
-	amqpURL := os.Getenv("RABBITMQ_URL")
-	if amqpURL == "" {
-		logError("missing_env", map[string]interface{}{
-			"env": "RABBITMQ_URL",
-		})
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "auth" {
+		if err := runAuthCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	}
+
+	cfg := loadConfig()
+	logger.Info("config_loaded", "config", config.Redacted(cfg))
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Flipped on SIGTERM so /readyz fails and Kubernetes stops routing to us.
+	var shuttingDown atomic.Bool
+
+	shutdownTracing, err := telemetry.Setup(sigCtx, "orders-api")
+	if err != nil {
+		logger.Error("tracing_setup_failed", "error", err.Error())
 		os.Exit(1)
 	}
 
-	postgresDSN := os.Getenv("POSTGRES_DSN")
-	if postgresDSN == "" {
-		logError("missing_env", map[string]interface{}{
-			"env": "POSTGRES_DSN",
-		})
+	authn, jwks, err := newAuthenticator(cfg.Auth)
+	if err != nil {
+		logger.Error("auth_setup_failed", "error", err.Error())
 		os.Exit(1)
 	}
+	if cfg.Auth.Disabled {
+		logger.Warn("auth_disabled")
+	}
+	limiter := newRateLimiter(cfg.RateLimit)
+	authFailures := newAuthFailureLimiter(cfg.RateLimit)
+	proxies, _ := parseTrustedProxies(cfg.HTTP.TrustedProxies) // checked by Validate
 
-	var err error
-	db, err = initDB(postgresDSN)
+	db, err = initDB(string(cfg.Postgres.DSN))
 	if err != nil {
-		logError("postgres_connect_failed", map[string]interface{}{
-			"error": err.Error(),
-		})
+		logger.Error("postgres_connect_failed", "error", err.Error())
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	queueName := "orders"
-
-	pub, err := newPublisher(amqpURL, queueName)
+	pub, err := newPublisher(string(cfg.RabbitMQ.URL), cfg.RabbitMQ.Queue, cfg.Publisher)
 	if err != nil {
-		logError("rabbitmq_connect_failed", map[string]interface{}{
-			"error": err.Error(),
-		})
+		logger.Error("rabbitmq_connect_failed", "error", err.Error())
 		os.Exit(1)
 	}
 	defer pub.Close()
 
+	relay := newOutboxRelay(db, pub, cfg.Outbox)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	hub := newEventHub(db, string(cfg.Postgres.DSN))
+
+	var bgWG sync.WaitGroup
+	bgWG.Add(6)
+	go func() {
+		defer bgWG.Done()
+		relay.Run(bgCtx)
+	}()
+	go func() {
+		defer bgWG.Done()
+		runIdempotencyJanitor(bgCtx)
+	}()
+	go func() {
+		defer bgWG.Done()
+		hub.Run(bgCtx)
+	}()
+	go func() {
+		defer bgWG.Done()
+		if jwks != nil {
+			jwks.Run(bgCtx, cfg.Auth.JWT.JWKSRefresh)
+		}
+	}()
+	go func() {
+		defer bgWG.Done()
+		if limiter != nil {
+			limiter.Run(bgCtx)
+		}
+	}()
+	go func() {
+		defer bgWG.Done()
+		if authFailures != nil {
+			authFailures.Run(bgCtx)
+		}
+	}()
+
 	mux := http.NewServeMux()
 
 	// Root HTML page
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		logAndCount(w, r, "index", func(w http.ResponseWriter) (int, error) {
-			orders, err := listOrders(r.Context())
+			q, err := parseListQuery(r.URL.Query(), cfg.List)
 			if err != nil {
-				logError("list_orders_failed", map[string]interface{}{
-					"error": err.Error(),
-				})
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return http.StatusBadRequest, err
+			}
+			page, err := listOrders(r.Context(), q)
+			if err != nil {
+				logger.ErrorContext(r.Context(), "list_orders_failed", "error", err.Error())
 				http.Error(w, "DB error", http.StatusInternalServerError)
 				return http.StatusInternalServerError, err
 			}
 			w.Header().Set("Content-Type", "text/html; charset=utf-8")
-			if err := indexTpl.Execute(w, struct{ Orders []OrderRow }{Orders: orders}); err != nil {
-				logError("template_execute_failed", map[string]interface{}{
-					"error": err.Error(),
-				})
+			err = indexTpl.Execute(w, struct {
+				Orders           []OrderRow
+				Limit            int
+				NextURL, PrevURL string
+				Live             bool
+			}{
+				Orders:  page.Orders,
+				Limit:   q.Limit,
+				Live:    q.ShowsNewest(),
+				NextURL: pageURL("/", r.URL.Query(), page.NextCursor),
+				PrevURL: pageURL("/", r.URL.Query(), page.PrevCursor),
+			})
+			if err != nil {
+				logger.ErrorContext(r.Context(), "template_execute_failed", "error", err.Error())
 				return http.StatusInternalServerError, err
 			}
 			return http.StatusOK, nil
@@ -706,11 +1083,20 @@ func main() {
 		})
 	})
 
-	// /readyz – readiness (simple: check connection open)
+	// /readyz – readiness. Orders are written to the Postgres outbox, so only
+	// the database is required; RabbitMQ state is exported as a gauge instead.
 	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
 		logAndCount(w, r, "readyz", func(w http.ResponseWriter) (int, error) {
-			if pub.ch == nil || pub.conn == nil || pub.conn.IsClosed() {
-				err := errors.New("rabbitmq_not_ready")
+			if shuttingDown.Load() {
+				err := errors.New("shutting_down")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte(err.Error()))
+				return http.StatusServiceUnavailable, err
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+			defer cancel()
+			if err := db.PingContext(ctx); err != nil {
+				err := errors.New("postgres_not_ready")
 				w.WriteHeader(http.StatusServiceUnavailable)
 				_, _ = w.Write([]byte(err.Error()))
 				return http.StatusServiceUnavailable, err
@@ -721,21 +1107,26 @@ func main() {
 		})
 	})
 
-	// /orders – GET = list (JSON), POST = publish order
+	// /orders – GET = list (JSON), POST = enqueue order via outbox
 	mux.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			logAndCount(w, r, "orders_list", func(w http.ResponseWriter) (int, error) {
-				orders, err := listOrders(r.Context())
+				q, err := parseListQuery(r.URL.Query(), cfg.List)
 				if err != nil {
-					logError("list_orders_failed", map[string]interface{}{
-						"error": err.Error(),
-					})
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+					return http.StatusBadRequest, err
+				}
+				page, err := listOrders(r.Context(), q)
+				if err != nil {
+					logger.ErrorContext(r.Context(), "list_orders_failed", "error", err.Error())
 					http.Error(w, "DB error", http.StatusInternalServerError)
 					return http.StatusInternalServerError, err
 				}
 				w.Header().Set("Content-Type", "application/json")
-				if err := json.NewEncoder(w).Encode(orders); err != nil {
+				if err := json.NewEncoder(w).Encode(page); err != nil {
 					return http.StatusInternalServerError, err
 				}
 				return http.StatusOK, nil
@@ -743,36 +1134,87 @@ func main() {
 
 		case http.MethodPost:
 			logAndCount(w, r, "orders_create", func(w http.ResponseWriter) (int, error) {
-				var req OrderRequest
-				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.OrderID == "" {
+				var req order.Order
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					if bodyTooLarge(w, err) {
+						return http.StatusRequestEntityTooLarge, err
+					}
+					w.Header().Set("Content-Type", "application/json")
 					w.WriteHeader(http.StatusBadRequest)
 					_, _ = w.Write([]byte(`{"error":"invalid payload"}`))
-					logError("order_invalid_payload", map[string]interface{}{
-						"error": err,
+					logger.ErrorContext(r.Context(), "order_invalid_payload", "error", err.Error())
+					return http.StatusBadRequest, err
+				}
+
+				req.Normalize()
+				r = r.WithContext(logging.WithOrderID(r.Context(), req.OrderID))
+
+				var invalid *order.ValidationError
+				if err := req.Validate(); errors.As(err, &invalid) {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{
+						"error":   "invalid payload",
+						"details": invalid.Problems,
 					})
+					logger.ErrorContext(r.Context(), "order_invalid_payload", "details", invalid.Problems)
 					return http.StatusBadRequest, err
 				}
 
-				if err := pub.PublishOrder(req); err != nil {
-					ordersPublishFailuresTotal.Inc()
+				key, ok := idempotencyKey(r, req)
+				if !ok {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					_, _ = w.Write([]byte(`{"error":"invalid idempotency key"}`))
+					return http.StatusBadRequest, errors.New("idempotency key too long")
+				}
+
+				resp, err := acceptOrder(r.Context(), cfg.Idempotency.TTL, key, req, pub.Admit)
+				switch {
+				case rejectSaturated(w, r, err, cfg.Publisher.Backpressure.RetryAfter):
+					return http.StatusServiceUnavailable, nil
+				case errors.Is(err, ErrIdempotencyMismatch):
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					_, _ = w.Write([]byte(`{"error":"idempotency key reused with a different payload"}`))
+					logger.ErrorContext(r.Context(), "order_idempotency_mismatch")
+					return http.StatusUnprocessableEntity, err
+				case errors.Is(err, ErrOrderExists):
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(`{"error":"order_id already exists"}`))
+					logger.InfoContext(r.Context(), "order_exists")
+					return http.StatusConflict, nil
+				case errors.Is(err, ErrIdempotencyInProgress):
+					w.Header().Set("Content-Type", "application/json")
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(http.StatusConflict)
+					_, _ = w.Write([]byte(`{"error":"request with this idempotency key is in progress"}`))
+					return http.StatusConflict, err
+				case err != nil:
+					w.Header().Set("Content-Type", "application/json")
 					w.WriteHeader(http.StatusInternalServerError)
-					_, _ = w.Write([]byte(`{"error":"publish failed"}`))
-					logError("order_publish_failed", map[string]interface{}{
-						"order_id": req.OrderID,
-						"error":    err.Error(),
-					})
+					_, _ = w.Write([]byte(`{"error":"store failed"}`))
+					logger.ErrorContext(r.Context(), "order_store_failed", "error", err.Error())
 					return http.StatusInternalServerError, err
 				}
 
-				ordersPublishedTotal.Inc()
-				logInfo("order_published", map[string]interface{}{
-					"order_id": req.OrderID,
-				})
+				if resp.Replayed {
+					ordersIdempotentReplaysTotal.Inc()
+					w.Header().Set("Idempotent-Replayed", "true")
+					logger.InfoContext(r.Context(), "order_replayed")
+				} else {
+					relay.Notify()
+					logger.InfoContext(r.Context(), "order_accepted")
+				}
 
+				if resp.Code == http.StatusAccepted {
+					w.Header().Set("Location", orderURL(req.OrderID))
+				}
 				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusAccepted)
-				_, _ = w.Write([]byte(`{"status":"accepted"}`))
-				return http.StatusAccepted, nil
+				w.WriteHeader(resp.Code)
+				_, _ = w.Write([]byte(resp.Body))
+				return resp.Code, nil
 			})
 
 		default:
@@ -780,19 +1222,120 @@ func main() {
 		}
 	})
 
+	// /orders:batch – bulk upload with a streamed per-record report
+	mux.HandleFunc("POST "+batchPath, batchOrdersHandler(cfg, pub, relay))
+
+	// /orders/stream – Server-Sent Events feed of order status changes.
+	// Not wrapped in logAndCount: streams stay open for minutes.
+	mux.Handle("GET /orders/stream", hub)
+
+	// /orders/{id} – status, history and failure reason of one order
+	mux.HandleFunc("GET /orders/{id}", func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(logging.WithOrderID(r.Context(), r.PathValue("id")))
+		logAndCount(w, r, "orders_get", func(w http.ResponseWriter) (int, error) {
+			o, err := getOrder(r.Context(), r.PathValue("id"))
+			if errors.Is(err, store.ErrNotFound) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"order not found"}`))
+				return http.StatusNotFound, nil
+			}
+			if err != nil {
+				logger.ErrorContext(r.Context(), "get_order_failed", "error", err.Error())
+				http.Error(w, "DB error", http.StatusInternalServerError)
+				return http.StatusInternalServerError, err
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(o); err != nil {
+				return http.StatusInternalServerError, err
+			}
+			return http.StatusOK, nil
+		})
+	})
+
+	// /orders/{id} cancellation, applied asynchronously by orders-worker
+	mux.HandleFunc("DELETE /orders/{id}", cancelOrderHandler(relay))
+	mux.HandleFunc("POST /orders/{id}/cancel", cancelOrderHandler(relay))
+
 	// /metrics – Prometheus
 	mux.Handle("/metrics", promhttp.Handler())
 
-	addr := ":8080"
-	logInfo("orders_api_starting", map[string]interface{}{
-		"addr": addr,
-	})
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		logError("http_server_failed", map[string]interface{}{
-			"error": err.Error(),
-		})
+	// Probes, metrics scrapes and long-lived streams are not traced.
+	bodyLimit := func(r *http.Request) int64 {
+		if r.URL.Path == batchPath {
+			return int64(cfg.Batch.MaxBodyBytes)
+		}
+		return int64(cfg.HTTP.MaxBodyBytes)
+	}
+	limited := withLimits(limiter, bodyLimit, proxies, mux)
+	traced := otelhttp.NewHandler(withRequestID(withAuth(authn, authFailures, proxies, limited)), "orders-api",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/healthz", "/readyz", "/metrics", "/orders/stream":
+				return false
+			}
+			return true
+		}),
+	)
+
+	srv := &http.Server{Addr: cfg.HTTP.Addr, Handler: traced}
+	srv.RegisterOnShutdown(hub.Close)
+	logger.Info("orders_api_starting", "addr", srv.Addr)
+
+	// ---- Admin listener (port-forward only) ----
+	adminMux := http.NewServeMux()
+	adminMux.Handle("GET /admin/config", config.Handler(cfg))
+	adminSrv := &http.Server{Addr: cfg.HTTP.AdminAddr, Handler: adminMux}
+
+	serveErr := make(chan error, 2)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	go func() {
+		logger.Info("orders_api_admin_listen", "addr", adminSrv.Addr)
+		serveErr <- adminSrv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		logger.Error("http_server_failed", "error", err.Error())
 		os.Exit(1)
+	case <-sigCtx.Done():
+	}
+
+	// ---- Graceful shutdown ----
+	logger.Info("orders_api_shutdown_started",
+		"drain_delay", cfg.Shutdown.DrainDelay.String(),
+		"timeout", cfg.Shutdown.Timeout.String(),
+	)
+	shuttingDown.Store(true)
+
+	// Give the endpoints controller time to notice /readyz before we stop listening.
+	time.Sleep(cfg.Shutdown.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Error("http_shutdown_failed", "error", err.Error())
+	}
+	_ = adminSrv.Shutdown(ctx)
+
+	// Stop the relay before the publisher goes away. Rows it published but could
+	// not mark sent are re-sent by the next replica; the worker ignores duplicates.
+	stopBackground()
+	bgWG.Wait()
+	pub.Close()
+	if err := db.Close(); err != nil {
+		logger.Error("postgres_close_failed", "error", err.Error())
 	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("tracing_shutdown_failed", "error", err.Error())
+	}
+
+	logger.Info("orders_api_stopped")
 }
 
 func logAndCount(
@@ -807,13 +1350,25 @@ func logAndCount(
 
 	httpRequestDuration.WithLabelValues(handler, r.Method).Observe(duration)
 	httpRequestsTotal.WithLabelValues(handler, r.Method, fmt.Sprint(code)).Inc()
+	if p := principalFrom(r.Context()); p != nil {
+		httpRequestsByPrincipal.WithLabelValues(p.Client, handler, fmt.Sprint(code)).Inc()
+	}
+
+	// The server span is started by otelhttp; name it after the handler.
+	span := trace.SpanFromContext(r.Context())
+	span.SetName(r.Method + " " + handler)
+	span.SetAttributes(attribute.String("orders.handler", handler))
+	if err != nil && code >= http.StatusInternalServerError {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 
 	if err != nil {
-		logError("http_request_error", map[string]interface{}{
-			"handler": handler,
-			"method":  r.Method,
-			"code":    code,
-			"error":   err.Error(),
-		})
+		logger.ErrorContext(r.Context(), "http_request_error",
+			"handler", handler,
+			"method", r.Method,
+			"code", code,
+			"error", err.Error(),
+		)
 	}
 }
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/praivan/orders-core/logging"
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/requestid"
+	"github.com/praivan/orders-core/telemetry"
+)
+
+// ---- Transactional outbox ----
+//
+// POST /orders only writes the order into order_outbox; the relay below is the
+// single path that publishes to RabbitMQ. Rows stay pending while the broker is
+// unavailable and are drained once the Publisher reconnects.
+
+const outboxPurgeInterval = 10 * time.Minute
+
+// enqueueOrder stores the order as a pending outbox row within tx.
+func enqueueOrder(ctx context.Context, tx *sql.Tx, o order.Order) error {
+	return enqueue(ctx, tx, order.TypeCreated, o.OrderID, o)
+}
+
+// enqueueCancellation stores a cancellation command as a pending outbox row within tx.
+func enqueueCancellation(ctx context.Context, tx *sql.Tx, c order.Cancellation) error {
+	return enqueue(ctx, tx, order.TypeCancelRequested, c.OrderID, c)
+}
+
+func enqueue(ctx context.Context, tx *sql.Tx, msgType, orderID string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	traceContext, err := json.Marshal(telemetry.Inject(ctx))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO order_outbox (order_id, message_type, payload, trace_context, request_id)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, ''))`,
+		orderID,
+		msgType,
+		payload,
+		traceContext,
+		requestid.FromContext(ctx),
+	)
+	return err
+}
+
+// encodeOutboxPayload wraps a stored payload in the message envelope.
+func encodeOutboxPayload(msgType string, payload []byte) ([]byte, error) {
+	switch msgType {
+	case order.TypeCreated:
+		var o order.Order
+		if err := json.Unmarshal(payload, &o); err != nil {
+			return nil, err
+		}
+		return order.Encode(o)
+	case order.TypeCancelRequested:
+		var c order.Cancellation
+		if err := json.Unmarshal(payload, &c); err != nil {
+			return nil, err
+		}
+		return order.EncodeCancellation(c)
+	default:
+		return nil, fmt.Errorf("unknown message type %q", msgType)
+	}
+}
+
+type OutboxRelay struct {
+	db   *sql.DB
+	pub  *Publisher
+	cfg  OutboxConfig
+	wake chan struct{}
+}
+
+func newOutboxRelay(db *sql.DB, pub *Publisher, cfg OutboxConfig) *OutboxRelay {
+	return &OutboxRelay{
+		db:   db,
+		pub:  pub,
+		cfg:  cfg,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Notify asks the relay to drain now instead of waiting for the next poll.
+func (r *OutboxRelay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains pending rows until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+
+		// Keep draining while full batches come back so a backlog clears quickly.
+		for {
+			n, err := r.drain(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Error("outbox_drain_failed", "error", err.Error())
+				}
+				break
+			}
+			if n < r.cfg.BatchSize {
+				break
+			}
+		}
+
+		r.updateGauges(ctx)
+
+		if time.Since(lastPurge) >= outboxPurgeInterval {
+			r.purgeSent(ctx)
+			lastPurge = time.Now()
+		}
+	}
+}
+
+// drain publishes one batch of pending rows with a single PublishBatch and
+// marks the confirmed ones sent. Rows are locked with SKIP LOCKED so several
+// orders-api replicas can relay in parallel.
+func (r *OutboxRelay) drain(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, order_id, message_type, payload, trace_context, COALESCE(request_id, '')
+		FROM order_outbox
+		WHERE sent_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, r.cfg.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("select pending: %w", err)
+	}
+
+	type pending struct {
+		id      int64
+		orderID string
+		msgType string
+		payload []byte
+		trace   []byte
+		reqID   string
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.orderID, &p.msgType, &p.payload, &p.trace, &p.reqID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan pending: %w", err)
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("select pending: %w", err)
+	}
+
+	// Publish under the trace and request ID of the request that enqueued each row.
+	var (
+		msgs    []outgoing
+		msgRows []pending
+		ctxs    = make([]context.Context, len(batch))
+		failed  = map[int64]error{}
+	)
+	for i, p := range batch {
+		var carrier map[string]string
+		_ = json.Unmarshal(p.trace, &carrier)
+		msgCtx := requestid.WithContext(telemetry.Extract(ctx, carrier), p.reqID)
+		msgCtx = logging.WithOrderID(msgCtx, p.orderID)
+		ctxs[i] = msgCtx
+
+		body, err := encodeOutboxPayload(p.msgType, p.payload)
+		if err != nil {
+			failed[p.id] = fmt.Errorf("decode outbox row %d: %w", p.id, err)
+			continue
+		}
+		msgs = append(msgs, outgoing{
+			ctx:       msgCtx,
+			messageID: outboxMessageID(p.reqID, p.id),
+			msgType:   p.msgType,
+			body:      body,
+		})
+		msgRows = append(msgRows, p)
+	}
+
+	var sentIDs []int64
+	var publishErr error
+	for i, err := range r.pub.PublishBatch(ctx, msgs) {
+		p, msgCtx := msgRows[i], msgs[i].ctx
+		if err != nil {
+			failed[p.id] = err
+			continue
+		}
+		sentIDs = append(sentIDs, p.id)
+		ordersPublishedTotal.Inc()
+		logger.InfoContext(msgCtx, "order_published",
+			"outbox_id", p.id,
+			"type", p.msgType,
+		)
+	}
+
+	if len(sentIDs) > 0 {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_outbox
+			SET sent_at = now(), attempts = attempts + 1, last_error = NULL
+			WHERE id = ANY($1)
+		`, pq.Array(sentIDs)); err != nil {
+			return 0, fmt.Errorf("mark sent: %w", err)
+		}
+	}
+
+	// Failed rows stay pending and are retried with the next drain; the
+	// worker tolerates the resulting reordering and duplicates.
+	for i, p := range batch {
+		err, ok := failed[p.id]
+		if !ok {
+			continue
+		}
+		if publishErr == nil {
+			publishErr = err
+		}
+		reason := publishFailureReason(err)
+		ordersPublishFailuresTotal.WithLabelValues(reason).Inc()
+		logger.ErrorContext(ctxs[i], "order_publish_failed",
+			"outbox_id", p.id,
+			"reason", reason,
+			"error", err.Error(),
+		)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE order_outbox
+			SET attempts = attempts + 1, last_error = $2
+			WHERE id = $1
+		`, p.id, err.Error()); err != nil {
+			return 0, fmt.Errorf("record failure: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit: %w", err)
+	}
+	return len(sentIDs), publishErr
+}
+
+// outboxMessageID derives the AMQP MessageId of an outbox row from the
+// request that wrote it; the row ID keeps it unique per message.
+func outboxMessageID(reqID string, id int64) string {
+	if reqID == "" {
+		return fmt.Sprintf("outbox-%d", id)
+	}
+	return fmt.Sprintf("%s-%d", reqID, id)
+}
+
+func (r *OutboxRelay) updateGauges(ctx context.Context) {
+	var (
+		count int64
+		age   float64
+	)
+	err := r.db.QueryRowContext(ctx, `
+		SELECT count(*), COALESCE(EXTRACT(EPOCH FROM now() - min(created_at)), 0)
+		FROM order_outbox
+		WHERE sent_at IS NULL
+	`).Scan(&count, &age)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("outbox_stats_failed", "error", err.Error())
+		}
+		return
+	}
+	outboxPending.Set(float64(count))
+	outboxOldestPendingAge.Set(age)
+}
+
+func (r *OutboxRelay) purgeSent(ctx context.Context) {
+	res, err := r.db.ExecContext(ctx, `
+		DELETE FROM order_outbox
+		WHERE sent_at IS NOT NULL AND sent_at < now() - make_interval(secs => $1)
+	`, r.cfg.Retention.Seconds())
+	if err != nil {
+		logger.Error("outbox_purge_failed", "error", err.Error())
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		logger.Info("outbox_purged", "rows", n)
+	}
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/requestid"
+	"github.com/praivan/orders-core/telemetry"
+	"github.com/praivan/orders-core/topology"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ---- RabbitMQ publisher ----
+
+var (
+	// ErrPublisherUnavailable is returned when no healthy channel became
+	// available within the publish wait budget (e.g. the broker is restarting).
+	ErrPublisherUnavailable = errors.New("rabbitmq publisher unavailable")
+
+	// ErrPublisherClosed is returned after Close has been called.
+	ErrPublisherClosed = errors.New("rabbitmq publisher closed")
+
+	// ErrPublishNacked is returned when the broker negatively acknowledged a message.
+	ErrPublishNacked = errors.New("rabbitmq nacked publish")
+
+	// ErrPublishConfirmTimeout is returned when no confirm arrived in time.
+	// The message may or may not have been persisted by the broker.
+	ErrPublishConfirmTimeout = errors.New("rabbitmq publish confirm timeout")
+)
+
+const (
+	reconnectBaseDelay = 500 * time.Millisecond
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+// Publisher owns a RabbitMQ connection and channel and transparently
+// re-establishes both (and re-declares the queue) when the broker goes away.
+type Publisher struct {
+	amqpURL   string
+	queueName string
+	cfg       PublisherConfig
+
+	mu    sync.RWMutex
+	conn  *amqp.Connection
+	ch    *amqp.Channel
+	ready chan struct{} // closed while conn/ch are usable
+
+	// Backpressure state, see backpressure.go.
+	queue         queueState
+	blocked       bool
+	blockedReason string
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+func newPublisher(amqpURL, queueName string, cfg PublisherConfig) (*Publisher, error) {
+	p := &Publisher{
+		amqpURL:   amqpURL,
+		queueName: queueName,
+		cfg:       cfg,
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	// Fail fast on the first connection so misconfiguration is obvious at startup.
+	conn, ch, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	p.setConnected(conn, ch)
+
+	logger.Info("rabbitmq_connected", "queue", queueName)
+
+	p.wg.Add(2)
+	go p.supervise(conn, ch)
+	go p.inspectLoop()
+
+	return p, nil
+}
+
+// dial opens a connection and a channel in confirm mode and declares the queue.
+func (p *Publisher) dial() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(p.amqpURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to enable publisher confirms: %w", err)
+	}
+
+	if _, err := topology.Declare(ch, p.queueName); err != nil {
+		_ = ch.Close()
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to declare queue: %w", err)
+	}
+
+	return conn, ch, nil
+}
+
+// supervise waits for the current connection or channel to close and then
+// reconnects with jittered exponential backoff until Close is called. It
+// also relays connection.blocked notifications.
+func (p *Publisher) supervise(conn *amqp.Connection, ch *amqp.Channel) {
+	defer p.wg.Done()
+
+	for {
+		connClosed := conn.NotifyClose(make(chan *amqp.Error, 1))
+		chClosed := ch.NotifyClose(make(chan *amqp.Error, 1))
+		blocked := conn.NotifyBlocked(make(chan amqp.Blocking, 1))
+
+		var reason *amqp.Error
+	wait:
+		for {
+			select {
+			case <-p.done:
+				return
+			case b, ok := <-blocked:
+				if !ok {
+					blocked = nil
+					continue
+				}
+				p.setBlocked(b)
+			case reason = <-connClosed:
+				break wait
+			case reason = <-chClosed:
+				break wait
+			}
+		}
+
+		p.setDisconnected()
+		p.setBlocked(amqp.Blocking{})
+		_ = ch.Close()
+		_ = conn.Close()
+
+		args := []any{"queue", p.queueName}
+		if reason != nil {
+			args = append(args, "error", reason.Error())
+		}
+		logger.Error("rabbitmq_connection_lost", args...)
+
+		var ok bool
+		conn, ch, ok = p.reconnect()
+		if !ok {
+			return
+		}
+	}
+}
+
+// reconnect retries dial until it succeeds or the publisher is closed.
+func (p *Publisher) reconnect() (*amqp.Connection, *amqp.Channel, bool) {
+	for attempt := 0; ; attempt++ {
+		delay := backoffDelay(attempt)
+		select {
+		case <-p.done:
+			return nil, nil, false
+		case <-time.After(delay):
+		}
+
+		conn, ch, err := p.dial()
+		if err != nil {
+			rabbitmqReconnectsTotal.WithLabelValues("failure").Inc()
+			logger.Error("rabbitmq_reconnect_failed",
+				"attempt", attempt+1,
+				"delay", delay.String(),
+				"error", err.Error(),
+			)
+			continue
+		}
+
+		select {
+		case <-p.done:
+			_ = ch.Close()
+			_ = conn.Close()
+			return nil, nil, false
+		default:
+		}
+
+		p.setConnected(conn, ch)
+		rabbitmqReconnectsTotal.WithLabelValues("success").Inc()
+		logger.Info("rabbitmq_reconnected", "queue", p.queueName, "attempt", attempt+1)
+		return conn, ch, true
+	}
+}
+
+// backoffDelay returns an exponentially growing delay with jitter in [d/2, d).
+func backoffDelay(attempt int) time.Duration {
+	d := reconnectMaxDelay
+	if attempt < 16 {
+		d = min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
+	}
+	half := d / 2
+	return half + rand.N(half)
+}
+
+func (p *Publisher) setConnected(conn *amqp.Connection, ch *amqp.Channel) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn = conn
+	p.ch = ch
+	close(p.ready)
+	rabbitmqConnected.Set(1)
+}
+
+func (p *Publisher) setDisconnected() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.conn = nil
+	p.ch = nil
+	p.ready = make(chan struct{})
+	rabbitmqConnected.Set(0)
+}
+
+// channel returns a healthy channel, waiting for a reconnect if needed.
+func (p *Publisher) channel(ctx context.Context) (*amqp.Channel, error) {
+	for {
+		p.mu.RLock()
+		ch, ready := p.ch, p.ready
+		p.mu.RUnlock()
+
+		if ch != nil && !ch.IsClosed() {
+			return ch, nil
+		}
+
+		select {
+		case <-p.done:
+			return nil, ErrPublisherClosed
+		case <-ctx.Done():
+			return nil, ErrPublisherUnavailable
+		case <-ready:
+		}
+	}
+}
+
+// Ready reports whether the publisher currently holds an open connection and channel.
+func (p *Publisher) Ready() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.conn != nil && p.ch != nil && !p.conn.IsClosed() && !p.ch.IsClosed()
+}
+
+// Publish publishes an encoded order message (see order.Encode) as a
+// persistent message and blocks until the broker confirms it. A nil error
+// means RabbitMQ has taken responsibility for it. The request ID in ctx is
+// sent as the CorrelationId.
+func (p *Publisher) Publish(ctx context.Context, messageID, msgType string, body []byte) error {
+	return p.PublishBatch(ctx, []outgoing{{
+		ctx:       ctx,
+		messageID: messageID,
+		msgType:   msgType,
+		body:      body,
+	}})[0]
+}
+
+// outgoing is one message of a PublishBatch call. Its ctx carries the trace
+// and request ID the message is published under.
+type outgoing struct {
+	ctx       context.Context
+	messageID string
+	msgType   string
+	body      []byte
+}
+
+// PublishBatch publishes msgs back to back and then waits for all their
+// confirms, so a batch costs one round trip instead of one per message.
+// errs[i] is the result for msgs[i] with the same meaning as for Publish;
+// once a publish fails, the remaining messages are not sent.
+func (p *Publisher) PublishBatch(ctx context.Context, msgs []outgoing) (errs []error) {
+	if len(msgs) == 0 {
+		return nil
+	}
+	errs = make([]error, len(msgs))
+	spans := make([]trace.Span, len(msgs))
+	defer func() {
+		for i, span := range spans {
+			if span == nil {
+				continue
+			}
+			if errs[i] != nil {
+				span.RecordError(errs[i])
+				span.SetStatus(codes.Error, errs[i].Error())
+			}
+			span.End()
+		}
+	}()
+
+	waitCtx, cancel := context.WithTimeout(ctx, p.cfg.Wait)
+	defer cancel()
+
+	ch, err := p.channel(waitCtx)
+	confirms := make([]*amqp.DeferredConfirmation, len(msgs))
+	for i, m := range msgs {
+		var msgCtx context.Context
+		msgCtx, spans[i] = p.startSpan(m)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		confirms[i], err = p.publish(waitCtx, msgCtx, ch, m)
+		errs[i] = err
+	}
+
+	confirmCtx, cancelConfirm := context.WithTimeout(ctx, p.cfg.ConfirmWait)
+	defer cancelConfirm()
+
+	for i, dc := range confirms {
+		if dc == nil {
+			continue
+		}
+		acked, err := dc.WaitContext(confirmCtx)
+		switch {
+		case err != nil:
+			errs[i] = ErrPublishConfirmTimeout
+		case !acked && ch.IsClosed():
+			// Outstanding confirms are nacked when the channel goes away.
+			errs[i] = fmt.Errorf("%w: channel closed before confirm", ErrPublisherUnavailable)
+		case !acked:
+			errs[i] = ErrPublishNacked
+		}
+	}
+	return errs
+}
+
+// startSpan starts the producer span of m.
+func (p *Publisher) startSpan(m outgoing) (context.Context, trace.Span) {
+	return tracer.Start(m.ctx, p.queueName+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(p.queueName),
+			semconv.MessagingMessageBodySize(len(m.body)),
+			semconv.MessagingMessageID(m.messageID),
+			semconv.MessagingMessageConversationID(requestid.FromContext(m.ctx)),
+			attribute.String("messaging.message.type", m.msgType),
+		),
+	)
+}
+
+// publish sends m on ch without waiting for its confirm. msgCtx is the
+// context of m's span, injected into the headers.
+func (p *Publisher) publish(ctx, msgCtx context.Context, ch *amqp.Channel, m outgoing) (*amqp.DeferredConfirmation, error) {
+	dc, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"",          // default exchange
+		p.queueName, // routing key
+		false,
+		false,
+		amqp.Publishing{
+			ContentType:   order.ContentType,
+			Type:          m.msgType,
+			Headers:       telemetry.InjectAMQP(msgCtx, nil),
+			DeliveryMode:  amqp.Persistent,
+			CorrelationId: requestid.FromContext(m.ctx),
+			MessageId:     m.messageID,
+			Timestamp:     time.Now().UTC(),
+			Body:          m.body,
+		},
+	)
+	if err != nil {
+		if errors.Is(err, amqp.ErrClosed) {
+			return nil, fmt.Errorf("%w: %v", ErrPublisherUnavailable, err)
+		}
+		return nil, err
+	}
+	return dc, nil
+}
+
+// publishFailureReason maps a Publish error to a metric label.
+func publishFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrPublisherUnavailable), errors.Is(err, ErrPublisherClosed):
+		return "unavailable"
+	case errors.Is(err, ErrPublishNacked):
+		return "nack"
+	case errors.Is(err, ErrPublishConfirmTimeout):
+		return "confirm_timeout"
+	default:
+		return "error"
+	}
+}
+
+func (p *Publisher) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.ch != nil {
+			_ = p.ch.Close()
+		}
+		if p.conn != nil {
+			_ = p.conn.Close()
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/praivan/orders-core/requestid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ---- Request IDs ----
+//
+// Every request gets an X-Request-ID: the client's if it is usable, a fresh
+// one otherwise. It is returned in the response, added to every log line
+// written with the request context, stored with the order and outbox row and
+// sent to orders-worker as the message CorrelationId.
+
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("http.request.id", id))
+
+		next.ServeHTTP(w, r.WithContext(requestid.WithContext(r.Context(), id)))
+	})
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/praivan/orders-core/order"
+)
+
+// ---- Live order feed ----
+//
+// Every status change inserts into order_status_history, whose trigger sends
+// the change on the order_events channel (migration 0008; 0013 cuts
+// failure_reason to 1000 characters to stay under pg_notify's payload limit).
+// EventHub LISTENs on it and fans events out to /orders/stream clients. The
+// history row ID is the SSE event ID, so a reconnecting client's
+// Last-Event-ID is replayed from the table and nothing is missed.
+
+const (
+	orderEventsChannel  = "order_events"
+	streamHeartbeat     = 15 * time.Second
+	streamBacklogLimit  = 500
+	streamClientBuffer  = 64
+	listenerMinInterval = time.Second
+	listenerMaxInterval = 30 * time.Second
+	// listenerPing checks the LISTEN connection, which can otherwise die
+	// silently behind a NAT or proxy while no order changes.
+	listenerPing = 90 * time.Second
+)
+
+// OrderEvent is one status change as sent to SSE clients.
+type OrderEvent struct {
+	ID            int64        `json:"id"`
+	OrderID       string       `json:"order_id"`
+	Status        order.Status `json:"status"`
+	Quantity      int          `json:"quantity"`
+	Currency      string       `json:"currency,omitempty"`
+	TotalCents    int64        `json:"total_cents"`
+	FailureReason string       `json:"failure_reason,omitempty"`
+	CreatedAt     time.Time    `json:"created_at"`
+	At            time.Time    `json:"at"`
+}
+
+type EventHub struct {
+	db  *sql.DB
+	dsn string
+
+	mu     sync.Mutex
+	subs   map[chan OrderEvent]struct{}
+	lastID int64
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newEventHub(db *sql.DB, dsn string) *EventHub {
+	return &EventHub{
+		db:   db,
+		dsn:  dsn,
+		subs: make(map[chan OrderEvent]struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Close ends every open stream; registered with http.Server.RegisterOnShutdown
+// because Shutdown would otherwise wait for them until it times out.
+func (h *EventHub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Subscribe returns a channel of live events. It is closed when the client
+// falls too far behind; the browser then reconnects with Last-Event-ID.
+func (h *EventHub) Subscribe() (chan OrderEvent, func()) {
+	ch := make(chan OrderEvent, streamClientBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	streamClients.Set(float64(len(h.subs)))
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+		streamClients.Set(float64(len(h.subs)))
+		h.mu.Unlock()
+	}
+}
+
+func (h *EventHub) broadcast(ev OrderEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// IDs are not strictly increasing in commit order, so events are never
+	// dropped for being older than the last one; lastID only bounds catchUp.
+	if ev.ID > h.lastID {
+		h.lastID = ev.ID
+	}
+
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+			streamClientsDroppedTotal.Inc()
+		}
+	}
+	streamClients.Set(float64(len(h.subs)))
+}
+
+// Run listens for notifications until ctx is cancelled.
+func (h *EventHub) Run(ctx context.Context) {
+	listener := pq.NewListener(h.dsn, listenerMinInterval, listenerMaxInterval,
+		func(ev pq.ListenerEventType, err error) {
+			switch ev {
+			case pq.ListenerEventConnectionAttemptFailed, pq.ListenerEventDisconnected:
+				logger.Error("order_events_listener_down", "error", fmt.Sprint(err))
+			case pq.ListenerEventReconnected:
+				logger.Info("order_events_listener_reconnected")
+			}
+		})
+	defer listener.Close()
+
+	if err := listener.Listen(orderEventsChannel); err != nil {
+		logger.Error("order_events_listen_failed", "error", err.Error())
+	}
+
+	// Start from the newest change so a fresh replica does not replay history.
+	var newest int64
+	if err := h.db.QueryRowContext(ctx,
+		`SELECT COALESCE(max(id), 0) FROM order_status_history`,
+	).Scan(&newest); err != nil && ctx.Err() == nil {
+		logger.Error("order_events_init_failed", "error", err.Error())
+	}
+	h.mu.Lock()
+	h.lastID = newest
+	h.mu.Unlock()
+
+	ping := time.NewTicker(listenerPing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-listener.Notify:
+			if n == nil {
+				// Reconnected: notifications sent meanwhile are lost, read them back.
+				h.catchUp(ctx)
+				continue
+			}
+			var ev OrderEvent
+			if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+				logger.Error("order_event_decode_failed", "error", err.Error())
+				continue
+			}
+			h.broadcast(ev)
+		case <-ping.C:
+			go func() { _ = listener.Ping() }()
+		}
+	}
+}
+
+func (h *EventHub) catchUp(ctx context.Context) {
+	h.mu.Lock()
+	after := h.lastID
+	h.mu.Unlock()
+
+	events, err := eventsSince(ctx, h.db, after)
+	if err != nil {
+		if ctx.Err() == nil {
+			logger.Error("order_events_catch_up_failed", "error", err.Error())
+		}
+		return
+	}
+	for _, ev := range events {
+		h.broadcast(ev)
+	}
+}
+
+// eventsSince returns up to streamBacklogLimit changes after the given event ID.
+func eventsSince(ctx context.Context, db *sql.DB, after int64) ([]OrderEvent, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT h.id, h.order_id, h.to_status, o.quantity, COALESCE(o.currency, ''),
+		       o.total_cents, COALESCE(o.failure_reason, ''), o.created_at, h.created_at
+		FROM order_status_history h
+		JOIN orders o ON o.order_id = h.order_id
+		WHERE h.id > $1
+		ORDER BY h.id
+		LIMIT $2
+	`, after, streamBacklogLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []OrderEvent
+	for rows.Next() {
+		var ev OrderEvent
+		if err := rows.Scan(&ev.ID, &ev.OrderID, &ev.Status, &ev.Quantity, &ev.Currency,
+			&ev.TotalCents, &ev.FailureReason, &ev.CreatedAt, &ev.At); err != nil {
+			return nil, err
+		}
+		out = append(out, ev)
+	}
+	return out, rows.Err()
+}
+
+// ServeHTTP streams events as text/event-stream until the client goes away
+// or the server shuts down.
+func (h *EventHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// Subscribe before reading the backlog so nothing falls in between;
+	// live copies of backlog events are skipped by ID below.
+	events, unsubscribe := h.Subscribe()
+	defer unsubscribe()
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var backlog []OrderEvent
+	if lastID != "" {
+		after, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || after < 0 {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		backlog, err = eventsSince(r.Context(), h.db, after)
+		if err != nil {
+			logger.Error("order_events_backlog_failed", "error", err.Error())
+			http.Error(w, "DB error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // disable proxy buffering (nginx)
+	w.WriteHeader(http.StatusOK)
+	_, _ = fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds())
+
+	write := func(ev OrderEvent) error {
+		b, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: order\ndata: %s\n\n", ev.ID, b)
+		return err
+	}
+
+	replayed := make(map[int64]bool, len(backlog))
+	for _, ev := range backlog {
+		if err := write(ev); err != nil {
+			return
+		}
+		replayed[ev.ID] = true
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-h.done:
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if replayed[ev.ID] {
+				continue
+			}
+			if err := write(ev); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
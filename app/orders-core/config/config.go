@@ -0,0 +1,281 @@
+// Package config loads a service's typed configuration. Values are applied
+// in order, each source overriding the previous one:
+//
+//  1. the defaults the caller put into the struct,
+//  2. the YAML file named by CONFIG_FILE, if set (unknown keys are errors),
+//  3. environment variables named by the `env` struct tags,
+//  4. files named by <VAR>_FILE, e.g. POSTGRES_DSN_FILE=/etc/orders/postgres-dsn
+//     for a mounted Kubernetes Secret (setting both VAR and VAR_FILE is an error).
+//
+// Fields are addressed by their `yaml` tag; nested structs are sections.
+// Supported field types are string, Secret, bool, int, float64 and
+// time.Duration. Callers validate the result themselves, since e.g. the
+// migrate subcommand only needs the Postgres section.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the optional YAML config file.
+const FileEnv = "CONFIG_FILE"
+
+// Secret is a string that is never shown in full by Redacted.
+type Secret string
+
+// Redacted returns URLs with the password and the values of sensitive query
+// parameters (password, sslpassword, tokens, keys) masked, and anything else
+// as "[redacted]".
+func (s Secret) Redacted() string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(string(s))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "[redacted]"
+	}
+	if u.User != nil {
+		// A user name alone may itself be a token.
+		if _, ok := u.User.Password(); !ok {
+			return "[redacted]"
+		}
+	}
+	if u.RawQuery != "" {
+		q, err := url.ParseQuery(u.RawQuery)
+		if err != nil {
+			return "[redacted]"
+		}
+		for name, values := range q {
+			if sensitiveParam(name) {
+				for i := range values {
+					values[i] = "xxxxx"
+				}
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u.Redacted()
+}
+
+// sensitiveParam reports whether a URL query parameter may hold a secret.
+func sensitiveParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"pass", "secret", "token", "key", "auth", "credential"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Load fills dst, a pointer to a struct holding the defaults, from the
+// config file, the environment and secret files.
+func Load(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return errors.New("config: Load needs a pointer to a struct")
+	}
+
+	if path := os.Getenv(FileEnv); path != "" {
+		if err := loadFile(path, dst); err != nil {
+			return err
+		}
+	}
+
+	var problems []error
+	walk(v.Elem(), "", func(f reflect.Value, key, env string) {
+		if env == "" {
+			return
+		}
+		raw, source, err := lookup(env)
+		if err != nil {
+			problems = append(problems, err)
+			return
+		}
+		if source == "" {
+			return
+		}
+		if err := set(f, raw); err != nil {
+			problems = append(problems, fmt.Errorf("%s (%s): %w", source, key, err))
+		}
+	})
+	return errors.Join(problems...)
+}
+
+func loadFile(path string, dst any) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(dst); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// lookup returns the value of env or of the file named by env_FILE, and the
+// name of the variable it came from ("" when neither is set).
+func lookup(env string) (string, string, error) {
+	value, inEnv := os.LookupEnv(env)
+	path, inFile := os.LookupEnv(env + "_FILE")
+	switch {
+	case inEnv && inFile:
+		return "", "", fmt.Errorf("%s and %s_FILE are both set", env, env)
+	case inFile:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", "", fmt.Errorf("%s_FILE: %w", env, err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), env + "_FILE", nil
+	case inEnv:
+		return value, env, nil
+	}
+	return "", "", nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func set(f reflect.Value, raw string) error {
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", raw)
+		}
+		f.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		f.SetInt(int64(n))
+	case reflect.Float64:
+		x, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		f.SetFloat(x)
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
+
+// walk calls fn for every leaf field of the struct v with its dotted yaml
+// key and env tag.
+func walk(v reflect.Value, prefix string, fn func(f reflect.Value, key, env string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		key := prefix + name
+
+		f := v.Field(i)
+		if f.Kind() == reflect.Struct && f.Type() != durationType {
+			walk(f, key+".", fn)
+			continue
+		}
+		fn(f, key, sf.Tag.Get("env"))
+	}
+}
+
+// Redacted returns the effective configuration as nested maps keyed like
+// the YAML file, with Secret values redacted. It is meant for admin
+// endpoints and startup logs.
+func Redacted(cfg any) map[string]any {
+	out := map[string]any{}
+	v := reflect.ValueOf(cfg)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	walk(v, "", func(f reflect.Value, key, _ string) {
+		m := out
+		parts := strings.Split(key, ".")
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := m[p].(map[string]any)
+			if !ok {
+				next = map[string]any{}
+				m[p] = next
+			}
+			m = next
+		}
+
+		var value any
+		switch x := f.Interface().(type) {
+		case Secret:
+			value = x.Redacted()
+		case time.Duration:
+			value = x.String()
+		default:
+			value = x
+		}
+		m[parts[len(parts)-1]] = value
+	})
+	return out
+}
+
+// Handler serves the effective configuration of cfg as JSON, redacted.
+func Handler(cfg any) http.Handler {
+	body, err := json.MarshalIndent(Redacted(cfg), "", "  ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	})
+}
+
+// Problems collects validation failures into one error.
+type Problems []string
+
+// Addf records a problem.
+func (p *Problems) Addf(format string, args ...any) {
+	*p = append(*p, fmt.Sprintf(format, args...))
+}
+
+// Err returns nil without problems, or an error listing all of them.
+func (p Problems) Err() error {
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config: %s", strings.Join(p, "; "))
+}
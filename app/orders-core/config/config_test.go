@@ -0,0 +1,216 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name    string        `yaml:"name" env:"TESTCFG_NAME"`
+	DSN     Secret        `yaml:"dsn" env:"TESTCFG_DSN"`
+	Debug   bool          `yaml:"debug" env:"TESTCFG_DEBUG"`
+	Workers int           `yaml:"workers" env:"TESTCFG_WORKERS"`
+	Ratio   float64       `yaml:"ratio" env:"TESTCFG_RATIO"`
+	Timeout time.Duration `yaml:"timeout" env:"TESTCFG_TIMEOUT"`
+	Section struct {
+		Addr  string `yaml:"addr" env:"TESTCFG_SECTION_ADDR"`
+		Fixed string `yaml:"fixed"`
+	} `yaml:"section"`
+}
+
+func defaults() testConfig {
+	var c testConfig
+	c.Name = "default"
+	c.Workers = 4
+	c.Timeout = 5 * time.Second
+	c.Section.Addr = ":8080"
+	c.Section.Fixed = "yes"
+	return c
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string            // CONFIG_FILE contents; none if empty
+		env     map[string]string // values of "@file:..." are written to a file first
+		want    func(*testConfig)
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			want: func(*testConfig) {},
+		},
+		{
+			name: "file overrides defaults",
+			yaml: "name: from-file\nworkers: 8\ntimeout: 1m\nsection:\n  addr: :9090\n",
+			want: func(c *testConfig) {
+				c.Name = "from-file"
+				c.Workers = 8
+				c.Timeout = time.Minute
+				c.Section.Addr = ":9090"
+			},
+		},
+		{
+			name: "env overrides file",
+			yaml: "name: from-file\nworkers: 8\n",
+			env: map[string]string{
+				"TESTCFG_NAME":         "from-env",
+				"TESTCFG_DEBUG":        "true",
+				"TESTCFG_RATIO":        "0.25",
+				"TESTCFG_TIMEOUT":      "250ms",
+				"TESTCFG_SECTION_ADDR": ":7070",
+			},
+			want: func(c *testConfig) {
+				c.Name = "from-env"
+				c.Workers = 8
+				c.Debug = true
+				c.Ratio = 0.25
+				c.Timeout = 250 * time.Millisecond
+				c.Section.Addr = ":7070"
+			},
+		},
+		{
+			name: "secret file overrides file",
+			yaml: "dsn: postgres://file@db/orders\n",
+			env:  map[string]string{"TESTCFG_DSN_FILE": "@file:postgres://orders:s3cret@db/orders\n"},
+			want: func(c *testConfig) { c.DSN = "postgres://orders:s3cret@db/orders" },
+		},
+		{
+			name:    "env and file both set",
+			env:     map[string]string{"TESTCFG_DSN": "a", "TESTCFG_DSN_FILE": "@file:b"},
+			wantErr: "TESTCFG_DSN and TESTCFG_DSN_FILE are both set",
+		},
+		{
+			name:    "missing secret file",
+			env:     map[string]string{"TESTCFG_DSN_FILE": "/nonexistent/dsn"},
+			wantErr: "TESTCFG_DSN_FILE:",
+		},
+		{
+			name:    "unknown key in file",
+			yaml:    "name: x\nworkerz: 8\n",
+			wantErr: "field workerz not found",
+		},
+		{
+			name:    "bad values are all reported",
+			env:     map[string]string{"TESTCFG_WORKERS": "many", "TESTCFG_TIMEOUT": "5"},
+			wantErr: `TESTCFG_WORKERS (workers): invalid integer "many"` + "\n" + `TESTCFG_TIMEOUT (timeout): invalid duration "5"`,
+		},
+		{
+			name:    "bad value from a file names the file variable",
+			env:     map[string]string{"TESTCFG_DEBUG_FILE": "@file:maybe"},
+			wantErr: `TESTCFG_DEBUG_FILE (debug): invalid boolean "maybe"`,
+		},
+		{
+			name: "empty file",
+			yaml: "# nothing yet\n",
+			want: func(*testConfig) {},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(FileEnv, "")
+			if tt.yaml != "" {
+				t.Setenv(FileEnv, writeFile(t, "config.yaml", tt.yaml))
+			}
+			for k, v := range tt.env {
+				if content, ok := strings.CutPrefix(v, "@file:"); ok {
+					v = writeFile(t, k, content)
+				}
+				t.Setenv(k, v)
+			}
+
+			got := defaults()
+			err := Load(&got)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			want := defaults()
+			tt.want(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Load = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadNeedsStructPointer(t *testing.T) {
+	var c testConfig
+	if err := Load(c); err == nil {
+		t.Error("Load(struct) succeeded, want an error")
+	}
+}
+
+func TestSecretRedacted(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"opaque-token", "[redacted]"},
+		{"host=db password=s3cret", "[redacted]"},
+		{"https://example.com/jwks.json", "https://example.com/jwks.json"},
+		{"postgres://orders:s3cret@db:5432/orders?sslmode=require", "postgres://orders:xxxxx@db:5432/orders?sslmode=require"},
+		{"amqp://s3cret-token@rabbitmq:5672/", "[redacted]"},
+		{
+			"postgres://db/orders?user=orders&password=s3cret&sslmode=disable",
+			"postgres://db/orders?password=xxxxx&sslmode=disable&user=orders",
+		},
+		{"postgres://db/orders?sslpassword=s3cret", "postgres://db/orders?sslpassword=xxxxx"},
+		{"https://example.com/jwks?API_KEY=abc&access_token=def", "https://example.com/jwks?API_KEY=xxxxx&access_token=xxxxx"},
+		{"https://example.com/cb#token=abc", "https://example.com/cb"},
+		{"https://example.com/?password=%zz", "[redacted]"},
+	}
+	for _, tt := range tests {
+		if got := Secret(tt.in).Redacted(); got != tt.want {
+			t.Errorf("Secret(%q).Redacted() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := defaults()
+	c.DSN = "postgres://orders:s3cret@db/orders"
+	got := Redacted(&c)
+	want := map[string]any{
+		"name":    "default",
+		"dsn":     "postgres://orders:xxxxx@db/orders",
+		"debug":   false,
+		"workers": 4,
+		"ratio":   0.0,
+		"timeout": "5s",
+		"section": map[string]any{"addr": ":8080", "fixed": "yes"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Redacted = %v, want %v", got, want)
+	}
+}
+
+func TestProblems(t *testing.T) {
+	var p Problems
+	if err := p.Err(); err != nil {
+		t.Errorf("Err() without problems = %v", err)
+	}
+	p.Addf("a must be set")
+	p.Addf("b must be at most %d", 3)
+	if got, want := p.Err().Error(), "invalid config: a must be set; b must be at most 3"; got != want {
+		t.Errorf("Err() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"net/url"
+	"time"
+)
+
+// Sections shared by both services.
+
+// Postgres configures the database connection.
+type Postgres struct {
+	DSN Secret `yaml:"dsn" env:"POSTGRES_DSN"`
+}
+
+// Validate reports a missing DSN.
+func (p Postgres) Validate(problems *Problems) {
+	if p.DSN == "" {
+		problems.Addf("postgres.dsn is required (POSTGRES_DSN or POSTGRES_DSN_FILE)")
+	}
+}
+
+// RabbitMQ configures the broker connection and the orders queue.
+type RabbitMQ struct {
+	URL   Secret `yaml:"url" env:"RABBITMQ_URL"`
+	Queue string `yaml:"queue" env:"RABBITMQ_QUEUE"`
+}
+
+// Validate reports a missing or malformed URL and an empty queue name.
+func (r RabbitMQ) Validate(problems *Problems) {
+	switch u, err := url.Parse(string(r.URL)); {
+	case r.URL == "":
+		problems.Addf("rabbitmq.url is required (RABBITMQ_URL or RABBITMQ_URL_FILE)")
+	case err != nil || (u.Scheme != "amqp" && u.Scheme != "amqps"):
+		problems.Addf("rabbitmq.url must be an amqp:// or amqps:// URL")
+	}
+	if r.Queue == "" {
+		problems.Addf("rabbitmq.queue must not be empty")
+	}
+}
+
+// Positive records a problem unless d > 0.
+func (p *Problems) Positive(key string, d time.Duration) {
+	if d <= 0 {
+		p.Addf("%s must be a positive duration, got %s", key, d)
+	}
+}
@@ -0,0 +1,164 @@
+// Package logging sets up log/slog for both services: one JSON object per
+// line on stdout, the format both services ship to Loki. Every line has
+// ts, level, msg, service and version; records logged with a context also
+// get its request_id, principal, order_id, trace_id and span_id.
+//
+// LOG_LEVEL (debug, info, warn, error; default info) sets the minimum level.
+// Per-message debug logging is sampled: SampleDebug marks a context in or
+// out with probability LOG_DEBUG_SAMPLE_RATE (0..1, default 0.1) and debug
+// records of sampled-out contexts are dropped.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/praivan/orders-core/principal"
+	"github.com/praivan/orders-core/requestid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logger is a slog.Logger that can also exit the process.
+type Logger struct {
+	*slog.Logger
+}
+
+// Fatal logs at error level and exits with status 1.
+func (l *Logger) Fatal(msg string, args ...any) {
+	l.Error(msg, args...)
+	os.Exit(1)
+}
+
+// New returns the logger of service, configured from LOG_LEVEL and
+// LOG_DEBUG_SAMPLE_RATE. Invalid values are reported and replaced by the
+// defaults. It also becomes the slog default logger.
+func New(service, version string) *Logger {
+	var problems []string
+
+	level := slog.LevelInfo
+	if levelEnv := os.Getenv("LOG_LEVEL"); levelEnv != "" {
+		if err := level.UnmarshalText([]byte(levelEnv)); err != nil {
+			problems = append(problems, fmt.Sprintf("LOG_LEVEL: invalid level %q", levelEnv))
+			level = slog.LevelInfo
+		}
+	}
+
+	rate := 0.1
+	if rateEnv := os.Getenv("LOG_DEBUG_SAMPLE_RATE"); rateEnv != "" {
+		r, err := strconv.ParseFloat(rateEnv, 64)
+		if err != nil || r < 0 || r > 1 {
+			problems = append(problems, fmt.Sprintf("LOG_DEBUG_SAMPLE_RATE: must be between 0 and 1, got %q", rateEnv))
+		} else {
+			rate = r
+		}
+	}
+	debugSampleRate = rate
+
+	h := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: replaceAttr,
+	})
+	l := slog.New(contextHandler{h}).With(
+		slog.String("service", service),
+		slog.String("version", version),
+	)
+	slog.SetDefault(l)
+
+	for _, p := range problems {
+		l.Warn("invalid_log_config", slog.String("error", p))
+	}
+	return &Logger{l}
+}
+
+// replaceAttr renames time to ts (UTC) and lower-cases the level.
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		if t, ok := a.Value.Any().(time.Time); ok {
+			return slog.String("ts", t.UTC().Format(time.RFC3339Nano))
+		}
+	case slog.LevelKey:
+		if l, ok := a.Value.Any().(slog.Level); ok {
+			return slog.String(slog.LevelKey, strings.ToLower(l.String()))
+		}
+	}
+	return a
+}
+
+// ---- Context attributes ----
+
+type orderIDKey struct{}
+
+type sampledKey struct{}
+
+var debugSampleRate = 0.1
+
+// WithOrderID returns ctx carrying the order ID for log records.
+func WithOrderID(ctx context.Context, orderID string) context.Context {
+	if orderID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, orderIDKey{}, orderID)
+}
+
+// SampleDebug decides once whether debug records logged with the returned
+// context are kept, so a message's debug lines are logged all or nothing.
+func SampleDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sampledKey{}, rand.Float64() < debugSampleRate)
+}
+
+// contextHandler adds request_id, principal, order_id, trace_id and span_id
+// from the context unless the record already has them, and applies debug
+// sampling.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level == slog.LevelDebug {
+		if sampled, ok := ctx.Value(sampledKey{}).(bool); ok && !sampled {
+			return nil
+		}
+	}
+
+	has := func(key string) bool {
+		found := false
+		r.Attrs(func(a slog.Attr) bool {
+			found = a.Key == key
+			return !found
+		})
+		return found
+	}
+	add := func(key, value string) {
+		if value != "" && !has(key) {
+			r.AddAttrs(slog.String(key, value))
+		}
+	}
+
+	add("request_id", requestid.FromContext(ctx))
+	add("principal", principal.FromContext(ctx))
+	orderID, _ := ctx.Value(orderIDKey{}).(string)
+	add("order_id", orderID)
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		add("trace_id", sc.TraceID().String())
+		add("span_id", sc.SpanID().String())
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,67 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Usage documents the subcommand handled by Command.
+const Usage = "migrate up | migrate down [steps] | migrate status"
+
+// Command implements the `migrate` subcommand shared by both binaries, e.g.
+// `orders-api migrate up` in the Kubernetes migration Job.
+func Command(ctx context.Context, db *sql.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", Usage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := Up(ctx, db)
+		for _, v := range applied {
+			fmt.Fprintf(out, "applied %04d\n", v)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "schema up to date")
+		}
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid steps %q", args[1])
+			}
+			steps = n
+		}
+		reverted, err := Down(ctx, db, steps)
+		for _, v := range reverted {
+			fmt.Fprintf(out, "reverted %04d\n", v)
+		}
+		return err
+
+	case "status":
+		statuses, err := StatusOf(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.UTC().Format("2006-01-02T15:04:05Z")
+			}
+			fmt.Fprintf(out, "%04d_%-28s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q (usage: %s)", args[0], Usage)
+	}
+}
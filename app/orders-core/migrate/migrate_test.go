@@ -0,0 +1,43 @@
+package migrate
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	ms, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(ms) == 0 {
+		t.Fatal("Load returned no migrations")
+	}
+
+	name := regexp.MustCompile(`^[a-z0-9_]+$`)
+	for i, m := range ms {
+		// Versions are contiguous from 1, so a gap or a duplicate number
+		// from a merge shows up here.
+		if m.Version != i+1 {
+			t.Errorf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+		if !name.MatchString(m.Name) {
+			t.Errorf("migration %04d has name %q", m.Version, m.Name)
+		}
+		if strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("migration %04d_%s has an empty up or down file", m.Version, m.Name)
+		}
+	}
+	if ms[0].Name != "create_orders" {
+		t.Errorf("first migration is %q, want create_orders", ms[0].Name)
+	}
+
+	latest, err := Latest()
+	if err != nil {
+		t.Fatalf("Latest: %v", err)
+	}
+	if latest != ms[len(ms)-1].Version {
+		t.Errorf("Latest = %d, want %d", latest, ms[len(ms)-1].Version)
+	}
+}
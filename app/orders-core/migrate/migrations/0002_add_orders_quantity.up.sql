@@ -0,0 +1,2 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS quantity INTEGER NOT NULL DEFAULT 1;
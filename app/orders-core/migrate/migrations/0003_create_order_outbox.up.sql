@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS order_outbox (
+    id         BIGSERIAL PRIMARY KEY,
+    order_id   TEXT NOT NULL,
+    payload    JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at    TIMESTAMPTZ,
+    attempts   INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS order_outbox_pending_idx
+    ON order_outbox (id) WHERE sent_at IS NULL;
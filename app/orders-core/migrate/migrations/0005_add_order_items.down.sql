@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_items;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS total_cents,
+    DROP COLUMN IF EXISTS currency;
@@ -0,0 +1,14 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS currency    TEXT,
+    ADD COLUMN IF NOT EXISTS total_cents BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS order_items (
+    order_id         TEXT    NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    line_no          INTEGER NOT NULL,
+    sku              TEXT    NOT NULL,
+    quantity         INTEGER NOT NULL CHECK (quantity > 0),
+    unit_price_cents BIGINT  NOT NULL CHECK (unit_price_cents >= 0),
+    PRIMARY KEY (order_id, line_no)
+);
+
+CREATE INDEX IF NOT EXISTS order_items_sku_idx ON order_items (sku);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS order_status_history;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS failure_reason,
+    DROP COLUMN IF EXISTS status;
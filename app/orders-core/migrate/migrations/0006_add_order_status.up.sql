@@ -0,0 +1,21 @@
+-- Orders stored before this migration were written by the worker after
+-- processing, so they are completed. New orders start out accepted.
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'completed'
+        CHECK (status IN ('accepted', 'processing', 'completed', 'failed', 'cancelled')),
+    ADD COLUMN IF NOT EXISTS failure_reason TEXT,
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+ALTER TABLE orders ALTER COLUMN status SET DEFAULT 'accepted';
+
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id          BIGSERIAL PRIMARY KEY,
+    order_id    TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    from_status TEXT,
+    to_status   TEXT NOT NULL,
+    reason      TEXT,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS order_status_history_order_id_idx
+    ON order_status_history (order_id, id);
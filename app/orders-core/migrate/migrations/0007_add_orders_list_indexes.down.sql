@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS orders_order_id_pattern_idx;
+DROP INDEX IF EXISTS orders_status_created_at_order_id_idx;
+DROP INDEX IF EXISTS orders_created_at_order_id_idx;
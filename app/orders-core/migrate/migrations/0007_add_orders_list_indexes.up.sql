@@ -0,0 +1,10 @@
+-- Keyset pagination of GET /orders over (created_at, order_id), optionally
+-- filtered by status, and order_id prefix search.
+CREATE INDEX IF NOT EXISTS orders_created_at_order_id_idx
+    ON orders (created_at, order_id);
+
+CREATE INDEX IF NOT EXISTS orders_status_created_at_order_id_idx
+    ON orders (status, created_at, order_id);
+
+CREATE INDEX IF NOT EXISTS orders_order_id_pattern_idx
+    ON orders (order_id text_pattern_ops);
@@ -0,0 +1,27 @@
+-- Publish every status change on the order_events channel for the live feed
+-- in orders-api. NOTIFY is delivered on commit, so listeners never see
+-- changes that were rolled back.
+CREATE OR REPLACE FUNCTION notify_order_event() RETURNS trigger AS $$
+DECLARE
+    o orders%ROWTYPE;
+BEGIN
+    SELECT * INTO o FROM orders WHERE order_id = NEW.order_id;
+    PERFORM pg_notify('order_events', json_build_object(
+        'id',             NEW.id,
+        'order_id',       NEW.order_id,
+        'status',         NEW.to_status,
+        'quantity',       o.quantity,
+        'currency',       o.currency,
+        'total_cents',    o.total_cents,
+        'failure_reason', o.failure_reason,
+        'created_at',     o.created_at,
+        'at',             NEW.created_at
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS order_status_history_notify ON order_status_history;
+CREATE TRIGGER order_status_history_notify
+    AFTER INSERT ON order_status_history
+    FOR EACH ROW EXECUTE FUNCTION notify_order_event();
@@ -0,0 +1,3 @@
+DELETE FROM order_outbox WHERE message_type <> 'order.created';
+
+ALTER TABLE order_outbox DROP COLUMN IF EXISTS message_type;
@@ -0,0 +1,3 @@
+-- The outbox now carries cancellation commands as well as new orders.
+ALTER TABLE order_outbox
+    ADD COLUMN IF NOT EXISTS message_type TEXT NOT NULL DEFAULT 'order.created';
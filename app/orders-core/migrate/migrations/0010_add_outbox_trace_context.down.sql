@@ -0,0 +1 @@
+ALTER TABLE order_outbox DROP COLUMN IF EXISTS trace_context;
@@ -0,0 +1,4 @@
+-- W3C trace context of the request that wrote the row, so the relay's
+-- publish span joins the original trace.
+ALTER TABLE order_outbox
+    ADD COLUMN IF NOT EXISTS trace_context JSONB;
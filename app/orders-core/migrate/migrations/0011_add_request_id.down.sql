@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS orders_request_id_idx;
+
+ALTER TABLE order_outbox DROP COLUMN IF EXISTS request_id;
+ALTER TABLE order_status_history DROP COLUMN IF EXISTS request_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS request_id;
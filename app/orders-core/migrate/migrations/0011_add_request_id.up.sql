@@ -0,0 +1,6 @@
+-- X-Request-ID of the request that created the order / caused the transition.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS request_id TEXT;
+ALTER TABLE order_status_history ADD COLUMN IF NOT EXISTS request_id TEXT;
+ALTER TABLE order_outbox ADD COLUMN IF NOT EXISTS request_id TEXT;
+
+CREATE INDEX IF NOT EXISTS orders_request_id_idx ON orders (request_id);
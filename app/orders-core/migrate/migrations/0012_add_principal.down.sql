@@ -0,0 +1,2 @@
+ALTER TABLE order_status_history DROP COLUMN IF EXISTS principal;
+ALTER TABLE orders DROP COLUMN IF EXISTS principal;
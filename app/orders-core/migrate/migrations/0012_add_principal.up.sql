@@ -0,0 +1,3 @@
+-- Authenticated caller that created the order / requested the transition.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS principal TEXT;
+ALTER TABLE order_status_history ADD COLUMN IF NOT EXISTS principal TEXT;
@@ -0,0 +1,23 @@
+-- pg_notify rejects payloads of 8000 bytes or more, and with it the status
+-- change that fired the trigger. failure_reason is the only unbounded field,
+-- so cap it at 1000 characters (at most 4000 bytes); GET /orders/{id} still
+-- shows it in full.
+CREATE OR REPLACE FUNCTION notify_order_event() RETURNS trigger AS $$
+DECLARE
+    o orders%ROWTYPE;
+BEGIN
+    SELECT * INTO o FROM orders WHERE order_id = NEW.order_id;
+    PERFORM pg_notify('order_events', json_build_object(
+        'id',             NEW.id,
+        'order_id',       NEW.order_id,
+        'status',         NEW.to_status,
+        'quantity',       o.quantity,
+        'currency',       o.currency,
+        'total_cents',    o.total_cents,
+        'failure_reason', left(o.failure_reason, 1000),
+        'created_at',     o.created_at,
+        'at',             NEW.created_at
+    )::text);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
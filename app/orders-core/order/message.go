@@ -0,0 +1,121 @@
+package order
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Messages on the orders queue are a versioned envelope around either an
+// Order (TypeCreated) or a Cancellation (TypeCancelRequested). Bump
+// SchemaVersion for incompatible changes and keep Decode accepting the
+// previous version until every producer has been upgraded.
+const (
+	SchemaVersion = 1
+	ContentType   = "application/json"
+
+	TypeCreated         = "order.created"
+	TypeCancelRequested = "order.cancel_requested"
+)
+
+// ErrUnsupportedVersion is returned by Decode for envelopes from a newer producer.
+var ErrUnsupportedVersion = errors.New("unsupported order message version")
+
+// Cancellation asks orders-worker to cancel an order.
+type Cancellation struct {
+	OrderID string `json:"order_id"`
+	Reason  string `json:"reason,omitempty"`
+	// RequestedBy is the principal that asked for the cancellation.
+	RequestedBy string `json:"requested_by,omitempty"`
+}
+
+// Envelope is the JSON body of every message on the orders queue. Exactly
+// one of Order and Cancellation is set, according to Type.
+type Envelope struct {
+	SchemaVersion int           `json:"schema_version"`
+	Type          string        `json:"type"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Order         *Order        `json:"order,omitempty"`
+	Cancellation  *Cancellation `json:"cancellation,omitempty"`
+}
+
+// OrderID is the order the message is about.
+func (e Envelope) OrderID() string {
+	switch {
+	case e.Order != nil:
+		return e.Order.OrderID
+	case e.Cancellation != nil:
+		return e.Cancellation.OrderID
+	}
+	return ""
+}
+
+// Encode wraps o in the current envelope.
+func Encode(o Order) ([]byte, error) {
+	return json.Marshal(Envelope{
+		SchemaVersion: SchemaVersion,
+		Type:          TypeCreated,
+		CreatedAt:     time.Now().UTC(),
+		Order:         &o,
+	})
+}
+
+// EncodeCancellation wraps c in the current envelope.
+func EncodeCancellation(c Cancellation) ([]byte, error) {
+	return json.Marshal(Envelope{
+		SchemaVersion: SchemaVersion,
+		Type:          TypeCancelRequested,
+		CreatedAt:     time.Now().UTC(),
+		Cancellation:  &c,
+	})
+}
+
+// Decode parses a message body and validates its payload; orders are
+// normalized first. Bodies without schema_version are bare orders from
+// producers that predate the envelope (and DLQ replays with a hand-edited
+// body) and are still accepted. Validation failures are returned as
+// *ValidationError together with the decoded envelope.
+func Decode(body []byte) (Envelope, error) {
+	var env Envelope
+	var probe struct {
+		SchemaVersion *int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return env, err
+	}
+
+	switch {
+	case probe.SchemaVersion == nil:
+		var o Order
+		if err := json.Unmarshal(body, &o); err != nil {
+			return env, err
+		}
+		env = Envelope{Type: TypeCreated, Order: &o}
+	case *probe.SchemaVersion == SchemaVersion:
+		if err := json.Unmarshal(body, &env); err != nil {
+			return env, err
+		}
+	default:
+		return env, fmt.Errorf("%w: %d", ErrUnsupportedVersion, *probe.SchemaVersion)
+	}
+
+	switch env.Type {
+	case TypeCreated:
+		if env.Order == nil {
+			return env, errors.New("message has no order")
+		}
+		env.Order.Normalize()
+		return env, env.Order.Validate()
+	case TypeCancelRequested:
+		if env.Cancellation == nil {
+			return env, errors.New("message has no cancellation")
+		}
+		if env.Cancellation.OrderID == "" {
+			return env, &ValidationError{Problems: []string{"order_id is required"}}
+		}
+		return env, nil
+	default:
+		return env, fmt.Errorf("unexpected message type %q", env.Type)
+	}
+}
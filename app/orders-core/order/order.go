@@ -0,0 +1,137 @@
+// Package order defines the order model shared by orders-api and
+// orders-worker, its validation rules and the message published to RabbitMQ.
+package order
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MaxOrderIDLen   = 128
+	MaxSKULen       = 64
+	MaxItems        = 100
+	MaxItemQuantity = 10000
+)
+
+var currencyRe = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Item is one order line. Prices are in minor units of the order currency.
+type Item struct {
+	SKU            string `json:"sku"`
+	Quantity       int    `json:"quantity"`
+	UnitPriceCents int64  `json:"unit_price_cents"`
+}
+
+// Order is the body of POST /orders and the payload of the order message.
+// Orders without items are still accepted; they count as Quantity units (default 1).
+type Order struct {
+	OrderID  string `json:"order_id"`
+	Quantity int    `json:"quantity,omitempty"`
+	Currency string `json:"currency,omitempty"`
+	Items    []Item `json:"items,omitempty"`
+}
+
+// ValidationError lists everything wrong with an order.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid order: " + strings.Join(e.Problems, "; ")
+}
+
+// Normalize fills in defaults: Quantity becomes the sum of item quantities
+// when items are given, and 1 when nothing was specified.
+func (o *Order) Normalize() {
+	if o.Quantity != 0 {
+		return
+	}
+	if len(o.Items) == 0 {
+		o.Quantity = 1
+		return
+	}
+	for _, it := range o.Items {
+		o.Quantity += it.Quantity
+	}
+}
+
+// TotalCents is the sum of quantity × unit price over all items.
+func (o *Order) TotalCents() int64 {
+	var total int64
+	for _, it := range o.Items {
+		total += int64(it.Quantity) * it.UnitPriceCents
+	}
+	return total
+}
+
+// Validate returns a *ValidationError describing every problem, or nil if
+// the order is acceptable. Call Normalize first.
+func (o *Order) Validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	switch {
+	case o.OrderID == "":
+		add("order_id is required")
+	case len(o.OrderID) > MaxOrderIDLen:
+		add("order_id must be at most %d characters", MaxOrderIDLen)
+	}
+
+	if o.Quantity < 1 || o.Quantity > MaxItemQuantity*MaxItems {
+		add("quantity must be between 1 and %d", MaxItemQuantity*MaxItems)
+	}
+
+	if o.Currency != "" && !currencyRe.MatchString(o.Currency) {
+		add("currency must be a 3-letter ISO 4217 code such as EUR")
+	}
+
+	if len(o.Items) > 0 {
+		if o.Currency == "" {
+			add("currency is required when items are given")
+		}
+		if len(o.Items) > MaxItems {
+			add("at most %d items are allowed", MaxItems)
+		}
+
+		total := 0
+		for i, it := range o.Items {
+			switch {
+			case it.SKU == "":
+				add("items[%d].sku is required", i)
+			case len(it.SKU) > MaxSKULen:
+				add("items[%d].sku must be at most %d characters", i, MaxSKULen)
+			}
+			if it.Quantity < 1 || it.Quantity > MaxItemQuantity {
+				add("items[%d].quantity must be between 1 and %d", i, MaxItemQuantity)
+			}
+			if it.UnitPriceCents < 0 {
+				add("items[%d].unit_price_cents must not be negative", i)
+			}
+			total += it.Quantity
+		}
+		if o.Quantity != total {
+			add("quantity must equal the sum of item quantities (%d)", total)
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// FormatMoney renders minor units as e.g. "12.50 EUR".
+func FormatMoney(cents int64, currency string) string {
+	if currency == "" {
+		return ""
+	}
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, cents/100, cents%100, currency)
+}
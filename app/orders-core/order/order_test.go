@@ -0,0 +1,255 @@
+package order
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Order
+		want int
+	}{
+		{"defaults to one", Order{OrderID: "o"}, 1},
+		{"sums items", Order{Items: []Item{{Quantity: 2}, {Quantity: 3}}}, 5},
+		{"keeps explicit quantity", Order{Quantity: 7, Items: []Item{{Quantity: 2}}}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.in
+			o.Normalize()
+			if o.Quantity != tt.want {
+				t.Errorf("Quantity = %d, want %d", o.Quantity, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	item := Item{SKU: "A-1", Quantity: 2, UnitPriceCents: 1250}
+	tooMany := make([]Item, MaxItems+1)
+	for i := range tooMany {
+		tooMany[i] = Item{SKU: "A", Quantity: 1}
+	}
+
+	tests := []struct {
+		name     string
+		in       Order
+		problems []string // substrings, in order; nil means valid
+	}{
+		{"minimal", Order{OrderID: "o-1"}, nil},
+		{"with items", Order{OrderID: "o-1", Currency: "EUR", Items: []Item{item}}, nil},
+		{"missing order_id", Order{}, []string{"order_id is required"}},
+		{"long order_id", Order{OrderID: strings.Repeat("x", MaxOrderIDLen+1)}, []string{"order_id must be at most"}},
+		{"negative quantity", Order{OrderID: "o", Quantity: -1}, []string{"quantity must be between"}},
+		{
+			"zero quantity",
+			Order{OrderID: "o", Currency: "EUR", Items: []Item{{SKU: "A-1", Quantity: 0}}},
+			[]string{"quantity must be between", "items[0].quantity must be between"},
+		},
+		{"lower-case currency", Order{OrderID: "o", Currency: "eur"}, []string{"currency must be a 3-letter"}},
+		{"items without currency", Order{OrderID: "o", Items: []Item{item}}, []string{"currency is required"}},
+		{"too many items", Order{OrderID: "o", Currency: "EUR", Items: tooMany}, []string{"at most 100 items"}},
+		{
+			"bad item",
+			Order{OrderID: "o", Currency: "EUR", Items: []Item{{Quantity: 0, UnitPriceCents: -1}}},
+			[]string{"quantity must be between", "items[0].sku is required", "items[0].quantity", "items[0].unit_price_cents"},
+		},
+		{
+			"quantity disagrees with items",
+			Order{OrderID: "o", Quantity: 3, Currency: "EUR", Items: []Item{item}},
+			[]string{"quantity must equal the sum of item quantities (2)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.in
+			o.Normalize()
+			err := o.Validate()
+			if tt.problems == nil {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Validate() = %v, want *ValidationError", err)
+			}
+			if len(invalid.Problems) != len(tt.problems) {
+				t.Fatalf("problems = %q, want %d matching %q", invalid.Problems, len(tt.problems), tt.problems)
+			}
+			for i, want := range tt.problems {
+				if !strings.Contains(invalid.Problems[i], want) {
+					t.Errorf("problems[%d] = %q, want it to contain %q", i, invalid.Problems[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	o := Order{OrderID: "o-1", Currency: "EUR", Items: []Item{{SKU: "A-1", Quantity: 2, UnitPriceCents: 100}}}
+	body, err := Encode(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err := Decode(body)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	o.Normalize()
+	if env.Type != TypeCreated || env.SchemaVersion != SchemaVersion || !reflect.DeepEqual(*env.Order, o) {
+		t.Errorf("Decode = %+v (order %+v), want %s v%d with %+v", env, env.Order, TypeCreated, SchemaVersion, o)
+	}
+
+	c := Cancellation{OrderID: "o-1", Reason: "changed mind"}
+	body, err = EncodeCancellation(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env, err = Decode(body)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if env.Type != TypeCancelRequested || env.Cancellation == nil || *env.Cancellation != c || env.OrderID() != "o-1" {
+		t.Errorf("Decode = %+v, want cancellation %+v", env, c)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		typ     string
+		orderID string
+		wantErr error // nil, a sentinel, or errValidation for *ValidationError
+		anyErr  bool
+	}{
+		{
+			name:    "legacy bare order",
+			body:    `{"order_id":"o-1","quantity":2}`,
+			typ:     TypeCreated,
+			orderID: "o-1",
+		},
+		{
+			name:    "legacy bare order is normalized",
+			body:    `{"order_id":"o-1","currency":"EUR","items":[{"sku":"A","quantity":3}]}`,
+			typ:     TypeCreated,
+			orderID: "o-1",
+		},
+		{
+			name:    "legacy bare order is validated",
+			body:    `{"quantity":2}`,
+			typ:     TypeCreated,
+			wantErr: errValidation,
+		},
+		{
+			name:    "envelope",
+			body:    `{"schema_version":1,"type":"order.created","order":{"order_id":"o-2"}}`,
+			typ:     TypeCreated,
+			orderID: "o-2",
+		},
+		{
+			name:    "newer schema",
+			body:    `{"schema_version":2,"type":"order.created","order":{"order_id":"o-2"}}`,
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:   "envelope without order",
+			body:   `{"schema_version":1,"type":"order.created"}`,
+			typ:    TypeCreated,
+			anyErr: true,
+		},
+		{
+			name:    "cancellation without order_id",
+			body:    `{"schema_version":1,"type":"order.cancel_requested","cancellation":{}}`,
+			typ:     TypeCancelRequested,
+			wantErr: errValidation,
+		},
+		{
+			name:   "unknown type",
+			body:   `{"schema_version":1,"type":"order.shipped"}`,
+			typ:    "order.shipped",
+			anyErr: true,
+		},
+		{
+			name:   "not JSON",
+			body:   `order o-1`,
+			anyErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, err := Decode([]byte(tt.body))
+			switch {
+			case tt.wantErr == errValidation:
+				var invalid *ValidationError
+				if !errors.As(err, &invalid) {
+					t.Fatalf("Decode error = %v, want *ValidationError", err)
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Decode error = %v, want %v", err, tt.wantErr)
+				}
+			case tt.anyErr:
+				if err == nil {
+					t.Fatal("Decode succeeded, want an error")
+				}
+			case err != nil:
+				t.Fatalf("Decode: %v", err)
+			}
+			if env.Type != tt.typ {
+				t.Errorf("Type = %q, want %q", env.Type, tt.typ)
+			}
+			if env.OrderID() != tt.orderID {
+				t.Errorf("OrderID() = %q, want %q", env.OrderID(), tt.orderID)
+			}
+		})
+	}
+}
+
+// errValidation stands for any *ValidationError in TestDecode.
+var errValidation = errors.New("validation error")
+
+func TestEnvelopeJSONNames(t *testing.T) {
+	body, err := Encode(Order{OrderID: "o-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"schema_version", "type", "created_at", "order"} {
+		if _, ok := m[key]; !ok {
+			t.Errorf("envelope has no %q: %s", key, body)
+		}
+	}
+	if _, ok := m["cancellation"]; ok {
+		t.Errorf("order envelope has a cancellation: %s", body)
+	}
+}
+
+func TestFormatMoney(t *testing.T) {
+	tests := []struct {
+		cents    int64
+		currency string
+		want     string
+	}{
+		{1250, "EUR", "12.50 EUR"},
+		{5, "USD", "0.05 USD"},
+		{-199, "EUR", "-1.99 EUR"},
+		{100, "", ""},
+	}
+	for _, tt := range tests {
+		if got := FormatMoney(tt.cents, tt.currency); got != tt.want {
+			t.Errorf("FormatMoney(%d, %q) = %q, want %q", tt.cents, tt.currency, got, tt.want)
+		}
+	}
+}
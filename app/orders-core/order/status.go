@@ -0,0 +1,51 @@
+package order
+
+// Status is where an order is in its lifecycle:
+//
+//	accepted ──► processing ──► completed
+//	    │            │
+//	    │            └────────► failed ──► processing (DLQ replay)
+//	    └──────────────────────► failed
+//	any status but cancelled ──► cancelled
+type Status string
+
+const (
+	StatusAccepted   Status = "accepted"
+	StatusProcessing Status = "processing"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+	StatusCancelled  Status = "cancelled"
+)
+
+var transitions = map[Status][]Status{
+	StatusAccepted:   {StatusProcessing, StatusFailed, StatusCancelled},
+	StatusProcessing: {StatusCompleted, StatusFailed, StatusCancelled},
+	StatusCompleted:  {StatusCancelled},
+	StatusFailed:     {StatusProcessing, StatusCancelled},
+}
+
+// CanTransition reports whether an order may move from one status to another.
+func CanTransition(from, to Status) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Terminal reports whether no further processing happens in this status.
+// Failed orders are terminal until their message is replayed from the DLQ;
+// completed orders can still be cancelled.
+func (s Status) Terminal() bool {
+	return s == StatusCompleted || s == StatusFailed || s == StatusCancelled
+}
+
+// Valid reports whether s is one of the known statuses.
+func (s Status) Valid() bool {
+	switch s {
+	case StatusAccepted, StatusProcessing, StatusCompleted, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
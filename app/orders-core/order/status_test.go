@@ -0,0 +1,58 @@
+package order
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from, to Status
+		want     bool
+	}{
+		{StatusAccepted, StatusProcessing, true},
+		{StatusAccepted, StatusFailed, true},
+		{StatusAccepted, StatusCancelled, true},
+		{StatusAccepted, StatusCompleted, false},
+		{StatusAccepted, StatusAccepted, false},
+		{StatusProcessing, StatusCompleted, true},
+		{StatusProcessing, StatusFailed, true},
+		{StatusProcessing, StatusCancelled, true},
+		{StatusProcessing, StatusAccepted, false},
+		{StatusCompleted, StatusCancelled, true},
+		{StatusCompleted, StatusProcessing, false},
+		{StatusCompleted, StatusFailed, false},
+		{StatusFailed, StatusProcessing, true},
+		{StatusFailed, StatusCancelled, true},
+		{StatusFailed, StatusCompleted, false},
+		{StatusCancelled, StatusProcessing, false},
+		{StatusCancelled, StatusCancelled, false},
+		{"", StatusProcessing, false},
+		{StatusAccepted, "shipped", false},
+	}
+	for _, tt := range tests {
+		if got := CanTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransition(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestStatusTerminalAndValid(t *testing.T) {
+	tests := []struct {
+		s               Status
+		terminal, valid bool
+	}{
+		{StatusAccepted, false, true},
+		{StatusProcessing, false, true},
+		{StatusCompleted, true, true},
+		{StatusFailed, true, true},
+		{StatusCancelled, true, true},
+		{"shipped", false, false},
+		{"", false, false},
+	}
+	for _, tt := range tests {
+		if got := tt.s.Terminal(); got != tt.terminal {
+			t.Errorf("%q.Terminal() = %v, want %v", tt.s, got, tt.terminal)
+		}
+		if got := tt.s.Valid(); got != tt.valid {
+			t.Errorf("%q.Valid() = %v, want %v", tt.s, got, tt.valid)
+		}
+	}
+}
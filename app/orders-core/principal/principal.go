@@ -0,0 +1,22 @@
+// Package principal carries the authenticated caller of an API request
+// through the context so it can be stored with the order and its status
+// history for audit, e.g. "apikey:checkout" or "jwt:user-42".
+package principal
+
+import "context"
+
+type contextKey struct{}
+
+// WithContext returns ctx carrying the principal id.
+func WithContext(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the principal stored by WithContext, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,53 @@
+// Package requestid carries the X-Request-ID of an API request through the
+// context, the outbox and RabbitMQ (as the message CorrelationId) so log
+// lines of both services can be matched with one query.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Header is the HTTP header clients may set and the API always returns.
+const Header = "X-Request-ID"
+
+// MaxLen bounds client supplied IDs.
+const MaxLen = 128
+
+type contextKey struct{}
+
+// New returns a random 128-bit ID in hex.
+func New() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Valid reports whether a client supplied ID can be used as is: non-empty,
+// at most MaxLen characters and limited to printable ASCII without spaces.
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithContext returns ctx carrying id.
+func WithContext(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID stored by WithContext, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,119 @@
+// Package store holds the SQL shared by orders-api and orders-worker for
+// moving orders through their status lifecycle. Every change is recorded in
+// order_status_history in the caller's transaction.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/principal"
+	"github.com/praivan/orders-core/requestid"
+)
+
+// ErrNotFound is returned when the order does not exist.
+var ErrNotFound = errors.New("order not found")
+
+// InsertAccepted stores o in the accepted status unless an order with the
+// same ID already exists. It reports whether a row was inserted. The request
+// ID and principal in ctx, if any, are stored with the order.
+func InsertAccepted(ctx context.Context, tx *sql.Tx, o order.Order) (bool, error) {
+	var currency sql.NullString
+	if o.Currency != "" {
+		currency = sql.NullString{String: o.Currency, Valid: true}
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO orders (order_id, quantity, currency, total_cents, status, request_id, principal)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+		ON CONFLICT (order_id) DO NOTHING
+	`, o.OrderID, o.Quantity, currency, o.TotalCents(), order.StatusAccepted,
+		requestid.FromContext(ctx), principal.FromContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("insert order: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if err := recordHistory(ctx, tx, o.OrderID, "", order.StatusAccepted, ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// InsertCancelled stores a cancelled placeholder for an order that has not
+// been created yet, so a create message arriving later is skipped. It reports
+// whether a row was inserted.
+func InsertCancelled(ctx context.Context, tx *sql.Tx, orderID, reason string) (bool, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO orders (order_id, status, request_id, principal)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''))
+		ON CONFLICT (order_id) DO NOTHING
+	`, orderID, order.StatusCancelled, requestid.FromContext(ctx), principal.FromContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("insert order: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if err := recordHistory(ctx, tx, orderID, "", order.StatusCancelled, reason); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// LockStatus returns the order's status and locks its row until tx ends.
+func LockStatus(ctx context.Context, tx *sql.Tx, orderID string) (order.Status, error) {
+	var status order.Status
+	err := tx.QueryRowContext(ctx,
+		`SELECT status FROM orders WHERE order_id = $1 FOR UPDATE`,
+		orderID,
+	).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("lock order: %w", err)
+	}
+	return status, nil
+}
+
+// Transition moves a row locked by LockStatus from one status to another.
+// reason is stored as the failure reason for failed orders and kept in the
+// history for every status.
+func Transition(ctx context.Context, tx *sql.Tx, orderID string, from, to order.Status, reason string) error {
+	if !order.CanTransition(from, to) {
+		return fmt.Errorf("order %s: illegal transition %s -> %s", orderID, from, to)
+	}
+
+	var failure sql.NullString
+	if to == order.StatusFailed && reason != "" {
+		failure = sql.NullString{String: reason, Valid: true}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		UPDATE orders
+		SET status = $2, failure_reason = $3, updated_at = now()
+		WHERE order_id = $1
+	`, orderID, to, failure)
+	if err != nil {
+		return fmt.Errorf("update status: %w", err)
+	}
+	return recordHistory(ctx, tx, orderID, from, to, reason)
+}
+
+func recordHistory(ctx context.Context, tx *sql.Tx, orderID string, from, to order.Status, reason string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO order_status_history (order_id, from_status, to_status, reason, request_id, principal)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''))
+	`, orderID, from, to, reason, requestid.FromContext(ctx), principal.FromContext(ctx))
+	if err != nil {
+		return fmt.Errorf("record status history: %w", err)
+	}
+	return nil
+}
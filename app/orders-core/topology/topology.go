@@ -0,0 +1,59 @@
+// Package topology names the RabbitMQ exchanges and queues used by the orders
+// services and declares them. Both services must declare the orders queue with
+// identical arguments or RabbitMQ refuses the declare, so this is the only
+// place that does it.
+package topology
+
+import (
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	// OrdersQueue receives order messages from orders-api.
+	OrdersQueue = "orders"
+
+	// DeadLetterExchange is the orders queue's DLX; a fanout into DeadLetterQueue.
+	DeadLetterExchange = "orders.dlx"
+	DeadLetterQueue    = "orders.dlq"
+)
+
+// Declare declares the dead-letter exchange and queue and the durable work
+// queue routed to them.
+func Declare(ch *amqp.Channel, queue string) (amqp.Queue, error) {
+	err := ch.ExchangeDeclare(
+		DeadLetterExchange,
+		amqp.ExchangeFanout,
+		true,  // durable
+		false, // auto-delete
+		false, // internal
+		false, // no-wait
+		nil,
+	)
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("declare %s: %w", DeadLetterExchange, err)
+	}
+
+	if _, err := ch.QueueDeclare(DeadLetterQueue, true, false, false, false, nil); err != nil {
+		return amqp.Queue{}, fmt.Errorf("declare %s: %w", DeadLetterQueue, err)
+	}
+	if err := ch.QueueBind(DeadLetterQueue, "", DeadLetterExchange, false, nil); err != nil {
+		return amqp.Queue{}, fmt.Errorf("bind %s: %w", DeadLetterQueue, err)
+	}
+
+	q, err := ch.QueueDeclare(
+		queue,
+		true,  // durable
+		false, // auto-delete
+		false, // exclusive
+		false, // no-wait
+		amqp.Table{
+			"x-dead-letter-exchange": DeadLetterExchange,
+		},
+	)
+	if err != nil {
+		return amqp.Queue{}, fmt.Errorf("declare %s: %w", queue, err)
+	}
+	return q, nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/topology"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ---- Dead-letter admin API ----
+//
+// Served on a separate listener (not the metrics NodePort) so that only
+// operators with port-forward access can replay messages.
+//
+// Every operation opens its own channel and basic.gets messages without
+// acking them; closing the channel hands everything not explicitly acked back
+// to the DLQ, so listing never consumes anything.
+
+const (
+	dlqDefaultLimit = 50
+	dlqScanLimit    = 10000
+)
+
+type DLQAdmin struct {
+	queue   string // queue replayed messages are sent back to
+	channel func() (*amqp.Channel, error)
+}
+
+func newDLQAdmin(queue string, channel func() (*amqp.Channel, error)) *DLQAdmin {
+	return &DLQAdmin{queue: queue, channel: channel}
+}
+
+// DeadLetter is the JSON view of one message in the DLQ.
+type DeadLetter struct {
+	ID            string                 `json:"id"`
+	MessageID     string                 `json:"message_id,omitempty"`
+	CorrelationID string                 `json:"correlation_id,omitempty"`
+	Reason        string                 `json:"reason,omitempty"`
+	Detail        string                 `json:"detail,omitempty"`
+	FailedAt      string                 `json:"failed_at,omitempty"`
+	Attempts      int                    `json:"attempts"`
+	Headers       map[string]interface{} `json:"headers,omitempty"`
+	Body          string                 `json:"body"`
+}
+
+type replayRequest struct {
+	IDs []string `json:"ids"`
+	All bool     `json:"all"`
+	// Bodies optionally replaces the body of the message with the given ID.
+	Bodies map[string]json.RawMessage `json:"bodies"`
+}
+
+type replayResponse struct {
+	Replayed []string `json:"replayed"`
+	Missing  []string `json:"missing,omitempty"`
+}
+
+// deadLetterID identifies a DLQ message: its MessageId if set, otherwise a
+// hash of the body (messages rejected straight into the DLX may carry no ID).
+func deadLetterID(msg amqp.Delivery) string {
+	if msg.MessageId != "" {
+		return msg.MessageId
+	}
+	sum := sha256.Sum256(msg.Body)
+	return "sha256:" + hex.EncodeToString(sum[:12])
+}
+
+func toDeadLetter(msg amqp.Delivery) DeadLetter {
+	str := func(k string) string {
+		s, _ := msg.Headers[k].(string)
+		return s
+	}
+	return DeadLetter{
+		ID:            deadLetterID(msg),
+		MessageID:     msg.MessageId,
+		CorrelationID: msg.CorrelationId,
+		Reason:        str(failureReasonHeader),
+		Detail:        str(failureDetailHeader),
+		FailedAt:      str(failedAtHeader),
+		Attempts:      retryAttempt(msg.Headers),
+		Headers:       msg.Headers,
+		Body:          string(msg.Body),
+	}
+}
+
+// scan fetches up to max messages from the DLQ on ch without acking them and
+// calls fn for each until fn returns false.
+func (a *DLQAdmin) scan(ch *amqp.Channel, max int, fn func(amqp.Delivery) bool) error {
+	for i := 0; i < max; i++ {
+		msg, ok, err := ch.Get(topology.DeadLetterQueue, false)
+		if err != nil {
+			return fmt.Errorf("get %s: %w", topology.DeadLetterQueue, err)
+		}
+		if !ok || !fn(msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// List returns up to limit dead-lettered messages, oldest first.
+func (a *DLQAdmin) List(limit int) ([]DeadLetter, error) {
+	ch, err := a.channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	out := []DeadLetter{}
+	err = a.scan(ch, limit, func(msg amqp.Delivery) bool {
+		out = append(out, toDeadLetter(msg))
+		return true
+	})
+	return out, err
+}
+
+// Get returns the dead-lettered message with the given ID.
+func (a *DLQAdmin) Get(id string) (*DeadLetter, error) {
+	ch, err := a.channel()
+	if err != nil {
+		return nil, err
+	}
+	defer ch.Close()
+
+	var found *DeadLetter
+	err = a.scan(ch, dlqScanLimit, func(msg amqp.Delivery) bool {
+		if deadLetterID(msg) == id {
+			dl := toDeadLetter(msg)
+			found = &dl
+			return false
+		}
+		return true
+	})
+	return found, err
+}
+
+// Replay republishes the selected messages (or all of them) to the orders
+// queue and removes them from the DLQ once the broker confirmed the copy.
+func (a *DLQAdmin) Replay(ctx context.Context, req replayRequest) (replayResponse, error) {
+	ch, err := a.channel()
+	if err != nil {
+		return replayResponse{}, err
+	}
+	defer ch.Close()
+
+	if err := ch.Confirm(false); err != nil {
+		return replayResponse{}, fmt.Errorf("confirm mode: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		wanted[id] = true
+	}
+
+	resp := replayResponse{Replayed: []string{}}
+	var replayErr error
+	err = a.scan(ch, dlqScanLimit, func(msg amqp.Delivery) bool {
+		id := deadLetterID(msg)
+		if !req.All && !wanted[id] {
+			return true
+		}
+
+		body, replaced := req.Bodies[id]
+		if !replaced {
+			body = msg.Body
+		}
+		if replayErr = a.republish(ctx, ch, msg, body, replaced); replayErr != nil {
+			return false
+		}
+		if replayErr = msg.Ack(false); replayErr != nil {
+			return false
+		}
+
+		workerReplayedTotal.Inc()
+		resp.Replayed = append(resp.Replayed, id)
+		delete(wanted, id)
+		return req.All || len(wanted) > 0
+	})
+	if err == nil {
+		err = replayErr
+	}
+
+	for id := range wanted {
+		resp.Missing = append(resp.Missing, id)
+	}
+	return resp, err
+}
+
+// republish sends msg back to the orders queue with the properties
+// republishing keeps. A replaced body may carry a different message, so it
+// gets the type of its own envelope and no AppId.
+func (a *DLQAdmin) republish(ctx context.Context, ch *amqp.Channel, msg amqp.Delivery, body []byte, replaced bool) error {
+	// Drop failure bookkeeping so the message starts over with a fresh retry budget.
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		switch k {
+		case failureReasonHeader, failureDetailHeader, failedAtHeader,
+			originalQueueHeader, retryAttemptHeader, "x-death",
+			"x-first-death-exchange", "x-first-death-queue", "x-first-death-reason",
+			"x-last-death-exchange", "x-last-death-queue", "x-last-death-reason":
+			continue
+		}
+		headers[k] = v
+	}
+	headers["x-replayed-at"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	publishing := republishing(msg, headers)
+	publishing.Body = body
+	if replaced {
+		env, _ := order.Decode(body)
+		publishing.Type, publishing.AppId = env.Type, ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	dc, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"",      // default exchange
+		a.queue, // routing key
+		false,
+		false,
+		publishing,
+	)
+	if err != nil {
+		return fmt.Errorf("publish to %s: %w", a.queue, err)
+	}
+	if acked, err := dc.WaitContext(ctx); err != nil || !acked {
+		return fmt.Errorf("publish to %s not confirmed", a.queue)
+	}
+	return nil
+}
+
+// Register mounts the admin endpoints on mux:
+//
+//	GET  /admin/dlq?limit=N   list dead-lettered messages
+//	GET  /admin/dlq/{id}      fetch one message
+//	POST /admin/dlq/replay    republish {"ids":[...]} or {"all":true}, optionally with "bodies"
+func (a *DLQAdmin) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /admin/dlq", func(w http.ResponseWriter, r *http.Request) {
+		limit := dlqDefaultLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > dlqScanLimit {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+				return
+			}
+			limit = n
+		}
+
+		msgs, err := a.List(limit)
+		if err != nil {
+			logger.Error("dlq_list_failed", "error", err.Error())
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": "dlq unavailable"})
+			return
+		}
+		writeJSON(w, http.StatusOK, msgs)
+	})
+
+	mux.HandleFunc("GET /admin/dlq/{id}", func(w http.ResponseWriter, r *http.Request) {
+		msg, err := a.Get(r.PathValue("id"))
+		if err != nil {
+			logger.Error("dlq_get_failed", "error", err.Error())
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": "dlq unavailable"})
+			return
+		}
+		if msg == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, msg)
+	})
+
+	mux.HandleFunc("POST /admin/dlq/replay", func(w http.ResponseWriter, r *http.Request) {
+		var req replayRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid payload"})
+			return
+		}
+		if !req.All && len(req.IDs) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "ids or all required"})
+			return
+		}
+
+		resp, err := a.Replay(r.Context(), req)
+		logger.Info("dlq_replayed", "count", len(resp.Replayed), "missing", len(resp.Missing))
+		if err != nil {
+			logger.Error("dlq_replay_failed", "error", err.Error())
+			writeJSON(w, http.StatusBadGateway, struct {
+				replayResponse
+				Error string `json:"error"`
+			}{resp, "replay interrupted"})
+			return
+		}
+		writeJSON(w, http.StatusOK, resp)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error("admin_write_failed", "error", err.Error())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"time"
+
+	"github.com/praivan/orders-core/config"
+	"github.com/praivan/orders-core/topology"
+)
+
+// Config is loaded once at startup by config.Load: defaults below, then
+// CONFIG_FILE, then the environment variables named in the tags (each also
+// accepted as <VAR>_FILE). GET /admin/config on the admin listener shows it.
+type Config struct {
+	HTTP     HTTPConfig      `yaml:"http"`
+	Postgres config.Postgres `yaml:"postgres"`
+	RabbitMQ config.RabbitMQ `yaml:"rabbitmq"`
+	Worker   WorkerConfig    `yaml:"worker"`
+	Shutdown ShutdownConfig  `yaml:"shutdown"`
+}
+
+type HTTPConfig struct {
+	// Addr serves /metrics, /healthz and /readyz.
+	Addr string `yaml:"addr" env:"HTTP_ADDR"`
+	// AdminAddr serves the dead-letter admin API and /admin/config.
+	AdminAddr string `yaml:"admin_addr" env:"ADMIN_ADDR"`
+}
+
+type WorkerConfig struct {
+	Concurrency int `yaml:"concurrency" env:"WORKER_CONCURRENCY"`
+	// Prefetch defaults to twice Concurrency when 0.
+	Prefetch int `yaml:"prefetch" env:"WORKER_PREFETCH"`
+}
+
+type ShutdownConfig struct {
+	// Timeout bounds how long in-flight messages may take to finish.
+	Timeout time.Duration `yaml:"timeout" env:"SHUTDOWN_TIMEOUT"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		HTTP:     HTTPConfig{Addr: ":8081", AdminAddr: ":8082"},
+		RabbitMQ: config.RabbitMQ{Queue: topology.OrdersQueue},
+		Worker:   WorkerConfig{Concurrency: 4},
+		Shutdown: ShutdownConfig{Timeout: 25 * time.Second},
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var p config.Problems
+	c.Postgres.Validate(&p)
+	c.RabbitMQ.Validate(&p)
+
+	if c.HTTP.Addr == "" || c.HTTP.AdminAddr == "" {
+		p.Addf("http.addr and http.admin_addr must not be empty")
+	} else if c.HTTP.Addr == c.HTTP.AdminAddr {
+		p.Addf("http.admin_addr must differ from http.addr")
+	}
+	if c.Worker.Concurrency < 1 {
+		p.Addf("worker.concurrency must be at least 1")
+	}
+	if c.Worker.Prefetch < 0 {
+		p.Addf("worker.prefetch must not be negative")
+	}
+	p.Positive("shutdown.timeout", c.Shutdown.Timeout)
+	return p.Err()
+}
+
+// loadConfig loads and validates the configuration or exits.
+func loadConfig() Config {
+	cfg := defaultConfig()
+	err := config.Load(&cfg)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		logger.Fatal("config_invalid", "error", err.Error())
+	}
+	if cfg.Worker.Prefetch == 0 {
+		cfg.Worker.Prefetch = 2 * cfg.Worker.Concurrency
+	}
+	return cfg
+}
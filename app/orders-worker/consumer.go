@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/praivan/orders-core/topology"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// ---- Supervised RabbitMQ consumer ----
+
+const (
+	reconnectBaseDelay = 500 * time.Millisecond
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+var errNotConnected = errors.New("rabbitmq not connected")
+
+// Consumer keeps a consumer subscribed to the orders queue, reconnecting and
+// re-declaring the topology whenever the connection or channel drops.
+type Consumer struct {
+	amqpURL     string
+	queue       string
+	tag         string
+	concurrency int
+	prefetch    int
+	drain       time.Duration
+	db          *sql.DB
+
+	mu   sync.RWMutex
+	conn *amqp.Connection
+}
+
+func newConsumer(amqpURL, queue, tag string, concurrency, prefetch int, drain time.Duration, db *sql.DB) *Consumer {
+	return &Consumer{
+		amqpURL:     amqpURL,
+		queue:       queue,
+		tag:         tag,
+		concurrency: concurrency,
+		prefetch:    prefetch,
+		drain:       drain,
+		db:          db,
+	}
+}
+
+// Connected reports whether a live connection is currently held.
+func (c *Consumer) Connected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.conn != nil && !c.conn.IsClosed()
+}
+
+// Channel opens a new channel on the current connection (used by the admin API).
+func (c *Consumer) Channel() (*amqp.Channel, error) {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	if conn == nil || conn.IsClosed() {
+		return nil, errNotConnected
+	}
+	return conn.Channel()
+}
+
+// Run consumes until ctx is cancelled, then stops the subscription and waits
+// up to the drain timeout for in-flight messages.
+func (c *Consumer) Run(ctx context.Context) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := backoffDelay(attempt - 1)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		conn, ch, err := c.connect()
+		if err != nil {
+			workerRabbitmqReconnectsTotal.WithLabelValues("failure").Inc()
+			logger.Error("rabbitmq_connect_failed", "attempt", attempt+1, "error", err.Error())
+			continue
+		}
+		if attempt > 0 {
+			workerRabbitmqReconnectsTotal.WithLabelValues("success").Inc()
+		}
+
+		started := time.Now()
+		if lost := c.session(ctx, conn, ch); !lost {
+			return
+		}
+		// A session that stayed up for a while starts the backoff schedule afresh.
+		if time.Since(started) > reconnectMaxDelay {
+			attempt = 0
+		}
+	}
+}
+
+// connect dials RabbitMQ and prepares a consuming channel.
+func (c *Consumer) connect() (*amqp.Connection, *amqp.Channel, error) {
+	conn, err := amqp.Dial(c.amqpURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dial: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("open channel: %w", err)
+	}
+
+	fail := func(err error) (*amqp.Connection, *amqp.Channel, error) {
+		_ = ch.Close()
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	// Confirms make sure a retry copy is stored before the original is acked.
+	if err := ch.Confirm(false); err != nil {
+		return fail(fmt.Errorf("confirm mode: %w", err))
+	}
+
+	// Never hold more unacked deliveries than the pool can work through soon.
+	if err := ch.Qos(c.prefetch, 0, false); err != nil {
+		return fail(fmt.Errorf("qos: %w", err))
+	}
+
+	if _, err := topology.Declare(ch, c.queue); err != nil {
+		return fail(err)
+	}
+	if err := declareRetryQueues(ch, c.queue); err != nil {
+		return fail(err)
+	}
+
+	return conn, ch, nil
+}
+
+// session consumes on ch until ctx is cancelled (returns false) or the
+// connection or channel is lost (returns true).
+func (c *Consumer) session(ctx context.Context, conn *amqp.Connection, ch *amqp.Channel) bool {
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		workerRabbitmqConnected.Set(0)
+		_ = ch.Close()
+		_ = conn.Close()
+	}()
+
+	msgs, err := ch.Consume(
+		c.queue,
+		c.tag,
+		false, // auto-ack
+		false, // exclusive
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		logger.Error("rabbitmq_consume_failed", "error", err.Error())
+		return true
+	}
+
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+	workerRabbitmqConnected.Set(1)
+	logger.Info("worker_consuming",
+		"queue", c.queue,
+		"concurrency", c.concurrency,
+		"prefetch", c.prefetch,
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for msg := range msgs {
+				processMessage(ch, c.queue, c.db, msg)
+			}
+		}()
+	}
+	poolDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(poolDone)
+	}()
+
+	select {
+	case <-poolDone:
+		// Deliveries stop when the channel or connection closes. Unacked
+		// messages are redelivered by the broker after we reconnect.
+		logger.Info("worker_msg_channel_closed")
+		return true
+	case <-ctx.Done():
+	}
+
+	// Stop new deliveries; prefetched but unprocessed ones are requeued when the channel closes.
+	if err := ch.Cancel(c.tag, false); err != nil {
+		logger.Error("rabbitmq_cancel_failed", "error", err.Error())
+	}
+
+	select {
+	case <-poolDone:
+		logger.Info("worker_drained")
+	case <-time.After(c.drain):
+		logger.Error("worker_drain_timeout")
+	}
+	return false
+}
+
+// backoffDelay returns an exponentially growing delay with jitter in [d/2, d).
+func backoffDelay(attempt int) time.Duration {
+	d := reconnectMaxDelay
+	if attempt < 16 {
+		d = min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
+	}
+	half := d / 2
+	return half + rand.N(half)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/praivan/orders-core/topology"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// Headers describing why a message ended up in the dead-letter queue.
+const (
+	failureReasonHeader = "x-failure-reason"
+	failureDetailHeader = "x-failure-detail"
+	failedAtHeader      = "x-failed-at"
+	originalQueueHeader = "x-original-queue"
+)
+
+// deadLetter publishes msg to the dead-letter exchange with the failure reason
+// in its headers and acks the original. If that publish fails the delivery is
+// rejected, which still routes it to the DLQ via the queue's DLX argument.
+func deadLetter(ctx context.Context, ch *amqp.Channel, queue string, msg amqp.Delivery, reason string, cause error) error {
+	workerDeadLetteredTotal.WithLabelValues(reason).Inc()
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[failureReasonHeader] = reason
+	headers[failedAtHeader] = time.Now().UTC().Format(time.RFC3339Nano)
+	headers[originalQueueHeader] = queue
+	if cause != nil {
+		headers[failureDetailHeader] = cause.Error()
+	}
+
+	// The admin API addresses DLQ entries by message ID, so make sure there is one.
+	messageID := msg.MessageId
+	if messageID == "" {
+		messageID = newMessageID()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	publishing := republishing(msg, headers)
+	publishing.MessageId = messageID
+	dc, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		topology.DeadLetterExchange,
+		queue, // routing key (ignored by the fanout exchange)
+		false,
+		false,
+		publishing,
+	)
+	if err == nil {
+		if acked, werr := dc.WaitContext(ctx); werr != nil || !acked {
+			err = fmt.Errorf("publish to %s not confirmed", topology.DeadLetterExchange)
+		}
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "order_dead_letter_publish_failed",
+			"reason", reason,
+			"error", err.Error(),
+		)
+		return msg.Nack(false, false)
+	}
+
+	logger.InfoContext(ctx, "order_dead_lettered",
+		"reason", reason,
+		"queue", topology.DeadLetterQueue,
+		"message_id", messageID,
+	)
+	return msg.Ack(false)
+}
+
+func newMessageID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -1,28 +1,14 @@
 package main
 
 import (
-	"encoding/json"
-	"os"
-	"time"
+	"github.com/praivan/orders-core/logging"
+	"github.com/praivan/orders-core/telemetry"
 )
 
-func logEvent(level, msg string, fields map[string]interface{}) {
-	entry := map[string]interface{}{
-		"ts":    time.Now().UTC().Format(time.RFC3339Nano),
-		"level": level,
-		"msg":   msg,
-	}
-	for k, v := range fields {
-		entry[k] = v
-	}
-	enc := json.NewEncoder(os.Stdout)
-	_ = enc.Encode(entry)
-}
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
 
-func logInfo(msg string, fields map[string]interface{}) {
-	logEvent("info", msg, fields)
-}
-
-func logError(msg string, fields map[string]interface{}) {
-	logEvent("error", msg, fields)
-}
+var (
+	logger = logging.New("orders-worker", version)
+	tracer = telemetry.Tracer("orders-worker")
+)
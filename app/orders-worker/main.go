@@ -4,30 +4,39 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
+	"github.com/praivan/orders-core/config"
+	"github.com/praivan/orders-core/logging"
+	"github.com/praivan/orders-core/migrate"
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/principal"
+	"github.com/praivan/orders-core/requestid"
+	"github.com/praivan/orders-core/telemetry"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type OrderMessage struct {
-	OrderID  string `json:"order_id"`
-	Quantity int    `json:"quantity"`
-}
-
 var (
 	workerMessagesTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "orders_worker_messages_total",
 			Help: "Total messages processed by the worker",
 		},
-		[]string{"status"}, // ok | decode_error | db_error
+		[]string{"status"}, // ok | duplicate | skipped | cancelled | decode_error | invalid | db_error | retries_exhausted
 	)
 
 	workerDBErrorsTotal = prometheus.NewCounter(
@@ -36,64 +45,123 @@ var (
 			Help: "Total DB errors in worker",
 		},
 	)
+
+	workerRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_worker_retries_total",
+			Help: "Total messages scheduled for retry, by attempt number",
+		},
+		[]string{"attempt"},
+	)
+
+	workerDeadLetteredTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_worker_dead_lettered_total",
+			Help: "Total messages moved to the orders dead-letter queue",
+		},
+		[]string{"reason"}, // decode_error | db_error | retries_exhausted
+	)
+
+	workerInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_worker_in_flight_messages",
+			Help: "Messages currently being processed by the worker pool",
+		},
+	)
+
+	workerMessageDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "orders_worker_message_duration_seconds",
+			Help:    "Time spent handling and settling one message",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"outcome"}, // ack | retry | reject
+	)
+
+	workerRabbitmqConnected = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "orders_worker_rabbitmq_connected",
+			Help: "Whether orders-worker currently holds a consuming RabbitMQ connection (1) or not (0)",
+		},
+	)
+
+	workerRabbitmqReconnectsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "orders_worker_rabbitmq_reconnects_total",
+			Help: "Total RabbitMQ reconnect attempts by orders-worker",
+		},
+		[]string{"result"}, // success | failure
+	)
+
+	workerReplayedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "orders_worker_dlq_replayed_total",
+			Help: "Total dead-lettered messages republished to the orders queue via the admin API",
+		},
+	)
 )
 
 func init() {
-	prometheus.MustRegister(workerMessagesTotal, workerDBErrorsTotal)
+	prometheus.MustRegister(
+		workerMessagesTotal,
+		workerDBErrorsTotal,
+		workerRetriesTotal,
+		workerDeadLetteredTotal,
+		workerReplayedTotal,
+		workerInFlight,
+		workerMessageDuration,
+		workerRabbitmqConnected,
+		workerRabbitmqReconnectsTotal,
+	)
 }
 
 func main() {
-	amqpURL := os.Getenv("RABBITMQ_URL")
-	if amqpURL == "" {
-		log.Fatalf(`{"event":"missing_env","env":"RABBITMQ_URL"}`)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
 	}
 
-	dsn := os.Getenv("POSTGRES_DSN")
-	if dsn == "" {
-		log.Fatalf(`{"event":"missing_env","env":"POSTGRES_DSN"}`)
-	}
+	cfg := loadConfig()
+	logger.Info("config_loaded", "config", config.Redacted(cfg))
 
-	// ---- Postgres ----
-	db, err := sql.Open("postgres", dsn)
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Flipped on SIGTERM so /readyz fails while in-flight messages drain.
+	var shuttingDown atomic.Bool
+
+	shutdownTracing, err := telemetry.Setup(sigCtx, "orders-worker")
 	if err != nil {
-		log.Fatalf(`{"event":"postgres_open_failed","error":%q}`, err.Error())
+		logger.Fatal("tracing_setup_failed", "error", err.Error())
 	}
+
+	// ---- Postgres ----
+	db := openDB(string(cfg.Postgres.DSN))
 	defer db.Close()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	if err := db.PingContext(ctx); err != nil {
-		log.Fatalf(`{"event":"postgres_ping_failed","error":%q}`, err.Error())
-	}
 
-	// ---- RabbitMQ ----
-	conn, err := amqp.Dial(amqpURL)
-	if err != nil {
-		log.Fatalf(`{"event":"rabbitmq_connect_failed","error":%q}`, err.Error())
+	// Refuse to insert into a schema this build does not understand yet.
+	if err := migrate.Check(ctx, db); err != nil {
+		logger.Fatal("postgres_schema_check_failed", "error", err.Error())
 	}
-	defer conn.Close()
 
-	ch, err := conn.Channel()
-	if err != nil {
-		log.Fatalf(`{"event":"rabbitmq_channel_failed","error":%q}`, err.Error())
+	// ---- RabbitMQ ----
+	consumerTag := fmt.Sprintf("orders-worker-%d", os.Getpid())
+	if host, err := os.Hostname(); err == nil {
+		consumerTag = "orders-worker-" + host
 	}
-	defer ch.Close()
+	consumer := newConsumer(string(cfg.RabbitMQ.URL), cfg.RabbitMQ.Queue, consumerTag,
+		cfg.Worker.Concurrency, cfg.Worker.Prefetch, cfg.Shutdown.Timeout, db)
 
-	q, err := ch.QueueDeclare(
-		"orders",
-		true,  // durable
-		false, // auto-delete
-		false, // exclusive
-		false, // no-wait
-		nil,
+	logger.Info("worker_started",
+		"queue", cfg.RabbitMQ.Queue,
+		"concurrency", cfg.Worker.Concurrency,
+		"prefetch", cfg.Worker.Prefetch,
 	)
-	if err != nil {
-		log.Fatalf(`{"event":"rabbitmq_queue_declare_failed","error":%q}`, err.Error())
-	}
-
-	log.Printf(`{"event":"worker_started","queue":%q}`, q.Name)
 
-	// ---- HTTP: /metrics, /healthz, /readyz on :8081 ----
+	// ---- HTTP: /metrics, /healthz, /readyz on http.addr (:8081) ----
 	mux := http.NewServeMux()
 
 	mux.Handle("/metrics", promhttp.Handler())
@@ -107,7 +175,12 @@ func main() {
 		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
 		defer cancel()
 
-		if err := db.PingContext(ctx); err != nil || conn.IsClosed() {
+		if shuttingDown.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("shutting-down"))
+			return
+		}
+		if err := db.PingContext(ctx); err != nil || !consumer.Connected() {
 			w.WriteHeader(http.StatusServiceUnavailable)
 			_, _ = w.Write([]byte("not-ready"))
 			return
@@ -116,62 +189,305 @@ func main() {
 		_, _ = w.Write([]byte("ready"))
 	})
 
+	metricsSrv := &http.Server{Addr: cfg.HTTP.Addr, Handler: mux}
 	go func() {
-		addr := ":8081"
-		log.Printf(`{"event":"worker_metrics_listen","addr":%q}`, addr)
-		if err := http.ListenAndServe(addr, mux); err != nil {
-			log.Fatalf(`{"event":"worker_http_server_failed","error":%q}`, err.Error())
+		logger.Info("worker_metrics_listen", "addr", metricsSrv.Addr)
+		if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("worker_http_server_failed", "error", err.Error())
 		}
 	}()
 
-	// ---- Consume messages forever ----
-	msgs, err := ch.Consume(
-		q.Name,
-		"",
-		true,  // auto-ack
-		false, // exclusive
-		false,
-		false,
-		nil,
-	)
+	// ---- HTTP: dead-letter admin API and config on http.admin_addr (:8082, not exposed via NodePort) ----
+	adminMux := http.NewServeMux()
+	newDLQAdmin(cfg.RabbitMQ.Queue, consumer.Channel).Register(adminMux)
+	adminMux.Handle("GET /admin/config", config.Handler(cfg))
+
+	adminSrv := &http.Server{Addr: cfg.HTTP.AdminAddr, Handler: adminMux}
+	go func() {
+		logger.Info("worker_admin_listen", "addr", adminSrv.Addr)
+		if err := adminSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("worker_admin_server_failed", "error", err.Error())
+		}
+	}()
+
+	// ---- Consume until SIGTERM ----
+	consumeDone := make(chan struct{})
+	go func() {
+		consumer.Run(sigCtx)
+		close(consumeDone)
+	}()
+
+	<-sigCtx.Done()
+
+	// ---- Graceful shutdown ----
+	logger.Info("worker_shutdown_started", "timeout", cfg.Shutdown.Timeout.String())
+	shuttingDown.Store(true)
+	<-consumeDone
+
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelHTTP()
+	_ = adminSrv.Shutdown(httpCtx)
+	_ = metricsSrv.Shutdown(httpCtx)
+
+	if err := shutdownTracing(httpCtx); err != nil {
+		logger.Error("tracing_shutdown_failed", "error", err.Error())
+	}
+
+	logger.Info("worker_stopped")
+}
+
+func openDB(dsn string) *sql.DB {
+	db, err := telemetry.OpenDB(dsn)
 	if err != nil {
-		log.Fatalf(`{"event":"rabbitmq_consume_failed","error":%q}`, err.Error())
+		logger.Fatal("postgres_open_failed", "error", err.Error())
 	}
 
-	for msg := range msgs {
-		handleMessage(db, msg.Body)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		logger.Fatal("postgres_ping_failed", "error", err.Error())
+	}
+	return db
+}
+
+// runMigrate implements `orders-worker migrate ...`; it only needs the
+// Postgres section of the configuration.
+func runMigrate(args []string) {
+	cfg := defaultConfig()
+	var problems config.Problems
+	err := config.Load(&cfg)
+	if err == nil {
+		cfg.Postgres.Validate(&problems)
+		err = problems.Err()
+	}
+	if err != nil {
+		logger.Fatal("config_invalid", "error", err.Error())
 	}
 
-	// We should never get here; if msgs closes, the process will exit and K8s will restart it.
-	log.Printf(`{"event":"worker_msg_channel_closed"}`)
+	db := openDB(string(cfg.Postgres.DSN))
+	defer db.Close()
+
+	if err := migrate.Command(context.Background(), db, args, os.Stdout); err != nil {
+		db.Close()
+		logger.Fatal("migrate_failed", "error", err.Error())
+	}
 }
 
-func handleMessage(db *sql.DB, body []byte) {
-	var m OrderMessage
-	m.Quantity = 1 // default quantity
+// processMessage handles and settles one delivery, recording pool metrics.
+func processMessage(ch *amqp.Channel, queue string, db *sql.DB, msg amqp.Delivery) {
+	workerInFlight.Inc()
+	defer workerInFlight.Dec()
 
-	if err := json.Unmarshal(body, &m); err != nil || m.OrderID == "" {
-		workerMessagesTotal.WithLabelValues("decode_error").Inc()
-		log.Printf(`{"event":"order_decode_failed","body":%q,"error":%q}`, string(body), err)
-		return
+	// Continue the trace started by orders-api; the headers survive retries
+	// and dead-lettering, so a replayed message joins the same trace. The
+	// CorrelationId is the X-Request-ID of the API request.
+	ctx := telemetry.ExtractAMQP(context.Background(), msg.Headers)
+	ctx = requestid.WithContext(ctx, msg.CorrelationId)
+	ctx, span := tracer.Start(ctx, queue+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemRabbitmq,
+			semconv.MessagingOperationTypeDeliver,
+			semconv.MessagingDestinationName(queue),
+			semconv.MessagingMessageBodySize(len(msg.Body)),
+			semconv.MessagingMessageID(msg.MessageId),
+			semconv.MessagingMessageConversationID(msg.CorrelationId),
+			attribute.String("messaging.message.type", msg.Type),
+			attribute.Int("messaging.rabbitmq.retry_attempt", retryAttempt(msg.Headers)),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	env, err := order.Decode(msg.Body)
+	if id := env.OrderID(); id != "" {
+		ctx = logging.WithOrderID(ctx, id)
+		span.SetAttributes(attribute.String("orders.order_id", id))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+	ctx = logging.SampleDebug(ctx)
+	logger.DebugContext(ctx, "message_received",
+		"message_id", msg.MessageId,
+		"type", env.Type,
+		"redelivered", msg.Redelivered,
+		"attempt", retryAttempt(msg.Headers),
+		"bytes", len(msg.Body),
+	)
 
-	_, err := db.ExecContext(
-		ctx,
-		`INSERT INTO orders (order_id, quantity) VALUES ($1, $2)`,
-		m.OrderID,
-		m.Quantity,
+	var res result
+	if err != nil {
+		res = decodeFailure(ctx, msg, env, err)
+	} else {
+		res = handleMessage(ctx, db, msg, env)
+	}
+	if res.err != nil {
+		span.RecordError(res.err)
+		span.SetStatus(codes.Error, res.reason)
+	}
+	if reason := settle(ctx, ch, queue, msg, res); reason != "" && res.orderID != "" {
+		markFailed(ctx, db, res.orderID, reason, res.err)
+	}
+	elapsed := time.Since(start)
+	workerMessageDuration.WithLabelValues(res.outcome.String()).Observe(elapsed.Seconds())
+	logger.DebugContext(ctx, "message_settled",
+		"outcome", res.outcome.String(),
+		"duration_ms", elapsed.Milliseconds(),
 	)
+}
+
+// settle acks, retries or dead-letters a delivery according to res. It returns
+// the failure reason when the message was dead-lettered.
+func settle(ctx context.Context, ch *amqp.Channel, queue string, msg amqp.Delivery, res result) (failure string) {
+	var err error
+	switch res.outcome {
+	case outcomeAck:
+		err = msg.Ack(false)
+	case outcomeRetry:
+		var scheduled bool
+		scheduled, err = scheduleRetry(ctx, ch, queue, msg)
+		if err != nil {
+			// Could not park it in a delay queue; requeue so it is not lost.
+			logger.ErrorContext(ctx, "order_retry_failed", "error", err.Error())
+			err = msg.Nack(false, true)
+			break
+		}
+		if !scheduled {
+			workerMessagesTotal.WithLabelValues("retries_exhausted").Inc()
+			logger.ErrorContext(ctx, "order_retries_exhausted",
+				"attempts", retryAttempt(msg.Headers),
+			)
+			failure = "retries_exhausted"
+			err = deadLetter(ctx, ch, queue, msg, failure, res.err)
+		}
+	case outcomeReject:
+		failure = res.reason
+		err = deadLetter(ctx, ch, queue, msg, failure, res.err)
+	}
 	if err != nil {
-		workerMessagesTotal.WithLabelValues("db_error").Inc()
-		workerDBErrorsTotal.Inc()
-		log.Printf(`{"event":"order_insert_failed","order_id":%q,"error":%q}`, m.OrderID, err.Error())
+		logger.ErrorContext(ctx, "order_settle_failed", "error", err.Error())
+	}
+	return failure
+}
+
+// markFailed records a dead-lettered order as failed so GET /orders/{id}
+// can report why. Replaying it from the DLQ moves it back to processing.
+func markFailed(ctx context.Context, db *sql.DB, orderID, reason string, cause error) {
+	if cause != nil {
+		reason += ": " + cause.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if err := failOrder(ctx, db, orderID, reason); err != nil {
+		logger.ErrorContext(ctx, "order_status_update_failed",
+			"order_id", orderID,
+			"error", err.Error(),
+		)
 		return
 	}
+	logger.InfoContext(ctx, "order_failed", "order_id", orderID, "reason", reason)
+}
+
+// decodeFailure rejects a message that could not be decoded. Malformed JSON
+// and orders failing validation will never succeed: dead-letter them.
+func decodeFailure(ctx context.Context, msg amqp.Delivery, env order.Envelope, err error) result {
+	status := "decode_error"
+	var invalid *order.ValidationError
+	if errors.As(err, &invalid) {
+		status = "invalid"
+	}
+	workerMessagesTotal.WithLabelValues(status).Inc()
+	logger.ErrorContext(ctx, "order_decode_failed",
+		"reason", status,
+		"body", string(msg.Body),
+		"error", err.Error(),
+	)
+	res := result{outcome: outcomeReject, reason: status, err: err}
+	if env.Type == order.TypeCreated {
+		res = res.failing(env.OrderID())
+	}
+	return res
+}
+
+func handleMessage(ctx context.Context, db *sql.DB, msg amqp.Delivery, env order.Envelope) result {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if env.Type == order.TypeCancelRequested {
+		return handleCancellation(ctx, db, msg, *env.Cancellation)
+	}
+	m := *env.Order
+
+	status, err := beginProcessing(ctx, db, m)
+	if err != nil {
+		return dbFailure(ctx, m.OrderID, msg, err).failing(m.OrderID)
+	}
+	if status != order.StatusProcessing {
+		// Completed orders are redeliveries (client retries, outbox re-sends).
+		label := "duplicate"
+		if status != order.StatusCompleted {
+			label = "skipped"
+		}
+		workerMessagesTotal.WithLabelValues(label).Inc()
+		logger.InfoContext(ctx, "order_"+label, "status", string(status))
+		return result{outcome: outcomeAck}
+	}
+
+	completed, err := completeOrder(ctx, db, m)
+	if err != nil {
+		return dbFailure(ctx, m.OrderID, msg, err).failing(m.OrderID)
+	}
+	if !completed {
+		workerMessagesTotal.WithLabelValues("skipped").Inc()
+		logger.InfoContext(ctx, "order_skipped")
+		return result{outcome: outcomeAck}
+	}
 
 	workerMessagesTotal.WithLabelValues("ok").Inc()
-	log.Printf(`{"event":"order_inserted","order_id":%q,"quantity":%d}`, m.OrderID, m.Quantity)
+	logger.InfoContext(ctx, "order_completed",
+		"quantity", m.Quantity,
+		"items", len(m.Items),
+	)
+	return result{outcome: outcomeAck}
+}
+
+// handleCancellation applies an order.cancel_requested command. The
+// requesting principal is recorded in the status history.
+func handleCancellation(ctx context.Context, db *sql.DB, msg amqp.Delivery, c order.Cancellation) result {
+	ctx = principal.WithContext(ctx, c.RequestedBy)
+	prev, err := cancelOrder(ctx, db, c)
+	if err != nil {
+		return dbFailure(ctx, c.OrderID, msg, err)
+	}
+
+	if prev == order.StatusCancelled {
+		workerMessagesTotal.WithLabelValues("duplicate").Inc()
+		logger.InfoContext(ctx, "order_cancel_duplicate")
+		return result{outcome: outcomeAck}
+	}
+
+	workerMessagesTotal.WithLabelValues("cancelled").Inc()
+	from := string(prev)
+	if prev == "" {
+		// Arrived before the order itself; the create will be skipped.
+		from = "unknown"
+	}
+	logger.InfoContext(ctx, "order_cancelled", "reason", c.Reason, "from", from)
+	return result{outcome: outcomeAck}
+}
+
+// dbFailure retries transient Postgres errors and rejects everything else.
+func dbFailure(ctx context.Context, orderID string, msg amqp.Delivery, err error) result {
+	workerMessagesTotal.WithLabelValues("db_error").Inc()
+	workerDBErrorsTotal.Inc()
+	logger.ErrorContext(ctx, "order_store_failed",
+		"order_id", orderID,
+		"attempt", retryAttempt(msg.Headers),
+		"error", err.Error(),
+	)
+	if isTransient(err) {
+		return result{outcome: outcomeRetry, reason: "db_error", err: err}
+	}
+	return result{outcome: outcomeReject, reason: "db_error", err: err}
 }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// outcome tells the consume loop what to do with a delivery.
+type outcome int
+
+const (
+	outcomeAck    outcome = iota // processed (or nothing left to do)
+	outcomeRetry                 // transient failure, try again later
+	outcomeReject                // permanent failure, never retry
+)
+
+func (o outcome) String() string {
+	switch o {
+	case outcomeAck:
+		return "ack"
+	case outcomeRetry:
+		return "retry"
+	default:
+		return "reject"
+	}
+}
+
+// result is handleMessage's verdict on a delivery. reason and err describe
+// why a message was retried or rejected.
+type result struct {
+	outcome outcome
+	reason  string
+	orderID string // order to mark failed if the message is dead-lettered
+	err     error
+}
+
+// failing marks the order as failed if r ends up dead-lettered.
+func (r result) failing(orderID string) result {
+	r.orderID = orderID
+	return r
+}
+
+// retryAttemptHeader carries how many times a message has been retried.
+const retryAttemptHeader = "x-retry-attempt"
+
+// retryDelays is the delay before each retry attempt. A message that still
+// fails after the last one is dead-lettered.
+var retryDelays = []time.Duration{
+	1 * time.Second,
+	5 * time.Second,
+	30 * time.Second,
+	2 * time.Minute,
+	5 * time.Minute,
+}
+
+// retryQueueName returns the delay queue used for the given attempt (1-based).
+func retryQueueName(queue string, attempt int) string {
+	return fmt.Sprintf("%s.retry.%ds", queue, int(retryDelays[attempt-1].Seconds()))
+}
+
+// declareRetryQueues declares one delay queue per retry attempt. Messages sit
+// there until their TTL expires and are then dead-lettered back to queue.
+func declareRetryQueues(ch *amqp.Channel, queue string) error {
+	for i, d := range retryDelays {
+		name := retryQueueName(queue, i+1)
+		_, err := ch.QueueDeclare(
+			name,
+			true,  // durable
+			false, // auto-delete
+			false, // exclusive
+			false, // no-wait
+			amqp.Table{
+				"x-message-ttl":             d.Milliseconds(),
+				"x-dead-letter-exchange":    "",
+				"x-dead-letter-routing-key": queue,
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("declare %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// retryAttempt reads the attempt count a delivery has already been through.
+func retryAttempt(h amqp.Table) int {
+	switch v := h[retryAttemptHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		n, _ := strconv.Atoi(v)
+		return n
+	default:
+		return 0
+	}
+}
+
+// scheduleRetry republishes msg to the next delay queue and acks the original.
+// It returns false when retries are exhausted and the caller should reject.
+func scheduleRetry(ctx context.Context, ch *amqp.Channel, queue string, msg amqp.Delivery) (bool, error) {
+	attempt := retryAttempt(msg.Headers) + 1
+	if attempt > len(retryDelays) {
+		return false, nil
+	}
+
+	headers := amqp.Table{}
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[retryAttemptHeader] = int32(attempt)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	target := retryQueueName(queue, attempt)
+	dc, err := ch.PublishWithDeferredConfirmWithContext(
+		ctx,
+		"",     // default exchange
+		target, // routing key
+		false,
+		false,
+		republishing(msg, headers),
+	)
+	if err != nil {
+		return true, fmt.Errorf("publish to %s: %w", target, err)
+	}
+	if acked, err := dc.WaitContext(ctx); err != nil || !acked {
+		return true, fmt.Errorf("publish to %s not confirmed", target)
+	}
+
+	workerRetriesTotal.WithLabelValues(strconv.Itoa(attempt)).Inc()
+	logger.InfoContext(ctx, "order_retry_scheduled", "attempt", attempt, "queue", target)
+	return true, msg.Ack(false)
+}
+
+// republishing copies msg into a persistent Publishing with headers in place
+// of its own. Every other property is kept, except UserId, because RabbitMQ
+// rejects publishes whose user-id is not the connection's user, and
+// Expiration, because a per-message TTL would let the copy expire while it
+// waits in a retry queue or orders.dlq.
+func republishing(msg amqp.Delivery, headers amqp.Table) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		AppId:           msg.AppId,
+		Body:            msg.Body,
+	}
+}
+
+// isTransient reports whether err is worth retrying (connection loss,
+// failover, resource exhaustion) rather than a problem with the data.
+func isTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"40", // transaction rollback (serialization, deadlock)
+			"53", // insufficient resources
+			"57", // operator intervention (admin shutdown, failover)
+			"58": // system error
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
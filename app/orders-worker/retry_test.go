@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestRetryAttempt(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers amqp.Table
+		want    int
+	}{
+		{"no headers", nil, 0},
+		{"int32", amqp.Table{retryAttemptHeader: int32(2)}, 2},
+		{"int64", amqp.Table{retryAttemptHeader: int64(3)}, 3},
+		{"int", amqp.Table{retryAttemptHeader: 4}, 4},
+		{"string", amqp.Table{retryAttemptHeader: "5"}, 5},
+		{"bad string", amqp.Table{retryAttemptHeader: "five"}, 0},
+		{"other type", amqp.Table{retryAttemptHeader: 1.5}, 0},
+		{"other header", amqp.Table{"x-death": int32(3)}, 0},
+	}
+	for _, tt := range tests {
+		if got := retryAttempt(tt.headers); got != tt.want {
+			t.Errorf("%s: retryAttempt = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryQueueName(t *testing.T) {
+	for attempt, want := range map[int]string{
+		1: "orders.retry.1s",
+		3: "orders.retry.30s",
+		5: "orders.retry.300s",
+	} {
+		if got := retryQueueName("orders", attempt); got != want {
+			t.Errorf("retryQueueName(orders, %d) = %q, want %q", attempt, got, want)
+		}
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline", context.DeadlineExceeded, true},
+		{"wrapped deadline", fmt.Errorf("insert: %w", context.DeadlineExceeded), true},
+		{"bad conn", driver.ErrBadConn, true},
+		{"network", &net.OpError{Op: "read", Net: "tcp", Err: timeoutError{}}, true},
+		{"connection failure", &pq.Error{Code: "08006"}, true},
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock", fmt.Errorf("update: %w", &pq.Error{Code: "40P01"}), true},
+		{"too many connections", &pq.Error{Code: "53300"}, true},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true},
+		{"system error", &pq.Error{Code: "58030"}, true},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+		{"check violation", &pq.Error{Code: "23514"}, false},
+		{"syntax error", &pq.Error{Code: "42601"}, false},
+		{"canceled", context.Canceled, false},
+		{"plain", errors.New("invalid order"), false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(tt.err); got != tt.want {
+			t.Errorf("%s: isTransient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRepublishing(t *testing.T) {
+	msg := amqp.Delivery{
+		Headers:         amqp.Table{"x-request-id": "r-1"},
+		ContentType:     "application/json",
+		ContentEncoding: "identity",
+		DeliveryMode:    amqp.Transient,
+		Priority:        3,
+		CorrelationId:   "c-1",
+		ReplyTo:         "replies",
+		Expiration:      "60000",
+		MessageId:       "m-1",
+		Timestamp:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Type:            "order.created",
+		UserId:          "orders-api",
+		AppId:           "orders-api",
+		Body:            []byte(`{}`),
+	}
+	headers := amqp.Table{"x-request-id": "r-1", retryAttemptHeader: int32(1)}
+
+	got := republishing(msg, headers)
+	want := amqp.Publishing{
+		Headers:         headers,
+		ContentType:     "application/json",
+		ContentEncoding: "identity",
+		DeliveryMode:    amqp.Persistent,
+		Priority:        3,
+		CorrelationId:   "c-1",
+		ReplyTo:         "replies",
+		MessageId:       "m-1",
+		Timestamp:       msg.Timestamp,
+		Type:            "order.created",
+		AppId:           "orders-api",
+		Body:            []byte(`{}`),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("republishing =\n%+v\nwant\n%+v", got, want)
+	}
+}
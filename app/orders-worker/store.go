@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/praivan/orders-core/order"
+	"github.com/praivan/orders-core/requestid"
+	"github.com/praivan/orders-core/store"
+)
+
+// beginProcessing moves the order to processing and returns its status. Any
+// status other than processing means there is nothing left to do (completed
+// redelivery, cancelled order). Orders published by an orders-api that did
+// not store them at acceptance yet are inserted here first.
+func beginProcessing(ctx context.Context, db *sql.DB, o order.Order) (order.Status, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := store.InsertAccepted(ctx, tx, o); err != nil {
+		return "", err
+	}
+
+	status, err := store.LockStatus(ctx, tx, o.OrderID)
+	if err != nil {
+		return "", err
+	}
+
+	// processing already: a retry, or a redelivery after a crash mid-message.
+	if status != order.StatusProcessing {
+		if !order.CanTransition(status, order.StatusProcessing) {
+			return status, nil
+		}
+		if err := store.Transition(ctx, tx, o.OrderID, status, order.StatusProcessing, ""); err != nil {
+			return "", err
+		}
+	}
+	return order.StatusProcessing, tx.Commit()
+}
+
+// completeOrder stores the line items and totals and marks the order
+// completed. It returns false without error when the order left the
+// processing status in the meantime (e.g. it was cancelled).
+func completeOrder(ctx context.Context, db *sql.DB, o order.Order) (bool, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status, err := store.LockStatus(ctx, tx, o.OrderID)
+	if err != nil {
+		return false, err
+	}
+	if status != order.StatusProcessing {
+		return false, nil
+	}
+
+	var currency sql.NullString
+	if o.Currency != "" {
+		currency = sql.NullString{String: o.Currency, Valid: true}
+	}
+
+	// Rows stored before request IDs existed pick up the message's one.
+	_, err = tx.ExecContext(ctx, `
+		UPDATE orders
+		SET quantity = $2, currency = $3, total_cents = $4,
+		    request_id = COALESCE(request_id, NULLIF($5, ''))
+		WHERE order_id = $1
+	`, o.OrderID, o.Quantity, currency, o.TotalCents(), requestid.FromContext(ctx))
+	if err != nil {
+		return false, err
+	}
+
+	for i, it := range o.Items {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO order_items (order_id, line_no, sku, quantity, unit_price_cents)
+			VALUES ($1, $2, $3, $4, $5)
+		`, o.OrderID, i+1, it.SKU, it.Quantity, it.UnitPriceCents)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if err := store.Transition(ctx, tx, o.OrderID, order.StatusProcessing, order.StatusCompleted, ""); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// failOrder marks the order failed with reason. Orders that are unknown or
+// already in a status that cannot fail are left alone.
+func failOrder(ctx context.Context, db *sql.DB, orderID, reason string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status, err := store.LockStatus(ctx, tx, orderID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !order.CanTransition(status, order.StatusFailed) {
+		return nil
+	}
+
+	if err := store.Transition(ctx, tx, orderID, status, order.StatusFailed, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// cancelOrder applies a cancellation and returns the status the order had
+// before. Accepted orders are skipped once their create message arrives;
+// processing ones stop at completeOrder; completed and failed ones are marked
+// cancelled. A cancellation that overtakes its create message leaves a
+// cancelled placeholder (previous status "") so the create is skipped too.
+func cancelOrder(ctx context.Context, db *sql.DB, c order.Cancellation) (order.Status, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status, err := store.LockStatus(ctx, tx, c.OrderID)
+	if errors.Is(err, store.ErrNotFound) {
+		inserted, err := store.InsertCancelled(ctx, tx, c.OrderID, c.Reason)
+		if err != nil {
+			return "", err
+		}
+		if inserted {
+			return "", tx.Commit()
+		}
+		// A concurrent create committed first; cancel its row instead.
+		status, err = store.LockStatus(ctx, tx, c.OrderID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if status == order.StatusCancelled {
+		return status, nil
+	}
+	if err := store.Transition(ctx, tx, c.OrderID, status, order.StatusCancelled, c.Reason); err != nil {
+		return "", err
+	}
+	return status, tx.Commit()
+}
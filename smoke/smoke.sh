@@ -19,6 +19,7 @@ need curl
 need jq
 need ssh
 need date
+need openssl
 
 SSH_USER="${SSH_USER:-ubuntu}"
 APPLY="${SMOKE_APPLY:-1}"
@@ -111,6 +112,11 @@ echo "==> Deploy app via app/deploy.sh"
 export IMAGE_API IMAGE_WORKER
 export RABBITMQ_URL="$RABBIT_URL"
 export POSTGRES_DSN="$POSTGRES_DSN"
+# orders-api requires an API key; provision one for the checks below unless
+# the caller brings its own key set.
+SMOKE_API_KEY="${SMOKE_API_KEY:-$(openssl rand -hex 24)}"
+SMOKE_API_KEY_HASH="$(printf '%s' "$SMOKE_API_KEY" | openssl dgst -sha256 | awk '{print $NF}')"
+export ORDERS_API_KEYS="${ORDERS_API_KEYS:+${ORDERS_API_KEYS};}smoke ${SMOKE_API_KEY_HASH} orders:read,orders:write"
 (cd "$APP_DIR" && ./deploy.sh)
 
 echo "==> Wait for app rollouts"
@@ -140,6 +146,7 @@ ORDER_ID="smoke-$(date +%s)"
 kubectl -n app-demo exec curl-demo -- \
   curl -fsS -X POST "http://orders-api:${API_PORT}/orders" \
     -H 'Content-Type: application/json' \
+    -H "X-API-Key: ${SMOKE_API_KEY}" \
     -d "{\"order_id\":\"${ORDER_ID}\",\"quantity\":1}" >/dev/null
 
 kubectl -n app-demo delete pod curl-demo --wait=false >/dev/null 2>&1 || true
@@ -150,7 +157,7 @@ echo "==> App check: API root returns HTML (port-forward quick check)"
 kubectl -n app-demo port-forward svc/orders-api 18080:8080 >/dev/null 2>&1 &
 PF_PID=$!
 sleep 1
-curl -fsS "http://127.0.0.1:18080/" >/dev/null || { kill $PF_PID || true; echo "API UI check failed"; exit 1; }
+curl -fsS -u "smoke:${SMOKE_API_KEY}" "http://127.0.0.1:18080/" >/dev/null || { kill $PF_PID || true; echo "API UI check failed"; exit 1; }
 kill $PF_PID || true
 
 echo "==> Prometheus check: key targets are UP (via monitoring VM)"